@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	prometheuslog "github.com/keithknott26/prometheuslog/pkg/app"
+)
+
+var (
+	backfillCommand     = app.Command("backfill", "Replay a log and its rotated copies through the rules and write OpenMetrics samples for promtool tsdb create-blocks-from openmetrics.")
+	backfillLog         = backfillCommand.Arg("log", "Log file; its rotated copies (log.1, log.2.gz, log-20260101.zst, ...) are read first, oldest first.").Required().String()
+	backfillApplication = backfillCommand.Flag("application", "Application name the lines belong to; with --config-file, its format and level options are used.").Short('a').Required().String()
+	backfillFormat      = backfillCommand.Flag("format", "Log format to unwrap: docker or cri. Overrides the config file's.").Enum("docker", "cri")
+	backfillInterval    = backfillCommand.Flag("interval", "Log time between samples.").Default("1m").Duration()
+	backfillOutput      = backfillCommand.Flag("output", "OpenMetrics file to write, or - for stdout.").Short('o').Default("-").String()
+	backfillNoRotated   = backfillCommand.Flag("no-rotated", "Only read the given log, not its rotated copies.").Bool()
+)
+
+// backfillLogs implements the backfill command and returns the exit code.
+func backfillLogs() int {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	application := prometheuslog.ApplicationConfig{Name: *backfillApplication}
+	if *configFile != "" {
+		applications, err := prometheuslog.ReadConfigFile(*configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		found := false
+		for _, configured := range applications {
+			if configured.Name == *backfillApplication {
+				application, found = configured, true
+				break
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "No application %s in %s\n", *backfillApplication, *configFile)
+			return 1
+		}
+	}
+	if *backfillFormat != "" {
+		application.Format = *backfillFormat
+	}
+	config.Applications = []prometheuslog.ApplicationConfig{application}
+	App, err := prometheuslog.New(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	paths := []string{*backfillLog}
+	if !*backfillNoRotated {
+		if paths, err = prometheuslog.RotatedLogs(*backfillLog); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stderr, "No log found at %s\n", *backfillLog)
+		return 1
+	}
+	for _, path := range paths {
+		fmt.Fprintf(os.Stderr, "Reading %s\n", path)
+	}
+
+	var w io.Writer = os.Stdout
+	if *backfillOutput != "-" {
+		file, err := os.Create(*backfillOutput)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	result, err := App.Backfill(*backfillApplication, paths, *backfillInterval, w)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d lines (%d without a timestamp), %d samples from %s to %s\n",
+		result.Lines, result.Untimed, result.Samples, result.Start.Format(time.RFC3339), result.End.Format(time.RFC3339))
+	return 0
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	prometheuslog "github.com/keithknott26/prometheuslog/pkg/app"
+)
+
+var checkCommand = app.Command("check-config", "Validate the config and rules files, if given, and the other flags, and exit non-zero on any problem.")
+
+// checkConfig implements the check-config command and returns the exit code.
+func checkConfig() int {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *configFile != "" {
+		config.Applications, err = prometheuslog.ReadConfigFile(*configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *stdinApplication != "" {
+		config.Applications = append(config.Applications, prometheuslog.ApplicationConfig{Name: *stdinApplication, LogPath: prometheuslog.StdinPath})
+	}
+
+	problems := config.Check()
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "\n%d problem(s) found.\n", len(problems))
+		return 1
+	}
+	fmt.Printf("OK: %d application(s), %d rule(s).\n", len(config.Applications), len(config.Rules))
+	return 0
+}
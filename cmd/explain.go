@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	prometheuslog "github.com/keithknott26/prometheuslog/pkg/app"
+)
+
+var (
+	explainCommand     = app.Command("explain", "Show which rules match a sample log line and the metric updates that would result.")
+	explainApplication = explainCommand.Arg("application", "Application name the line belongs to.").Required().String()
+	explainLine        = explainCommand.Arg("line", "Sample log line. Lines are read from stdin when omitted.").String()
+	explainJSON        = explainCommand.Flag("json", "Print the explanation as JSON.").Bool()
+	explainFields      = explainCommand.Flag("field", "Input field of the line as name=value, e.g. severity=err for syslog; repeatable.").StringMap()
+)
+
+// explainLines implements the explain command and returns the exit code.
+func explainLines() int {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	App, err := prometheuslog.New(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	explain := func(line string) {
+		explanation := App.Explain(*explainApplication, line, *explainFields)
+		if *explainJSON {
+			json.NewEncoder(os.Stdout).Encode(explanation)
+		} else {
+			fmt.Println(explanation)
+		}
+	}
+	if *explainLine != "" {
+		explain(*explainLine)
+		return 0
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		explain(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
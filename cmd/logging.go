@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/as/hue"
+)
+
+var (
+	logFormat = app.Flag("log-format", "Log format: text or json. Text is colored when writing to a terminal.").Default("text").Enum("text", "json")
+	logLevel  = app.Flag("log-level", "Minimum log level: debug, info, warn or error. --debug implies debug.").Default("info").Enum("debug", "info", "warn", "error")
+)
+
+// newLogger builds the exporter's logger from the flags. It writes to
+// stdout and never replaces the standard library's default logger.
+func newLogger() *slog.Logger {
+	var level slog.Level
+	level.UnmarshalText([]byte(*logLevel))
+	if *debug {
+		level = slog.LevelDebug
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	if *logFormat == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stdout, options))
+	}
+	if !isTerminal(os.Stdout) {
+		return slog.New(slog.NewTextHandler(os.Stdout, options))
+	}
+	buf := &bytes.Buffer{}
+	return slog.New(&colorHandler{
+		Handler: slog.NewTextHandler(buf, options),
+		mu:      &sync.Mutex{},
+		buf:     buf,
+		out:     hue.NewWriter(os.Stdout, hue.New(hue.Default, hue.Default)),
+	})
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorHandler formats records with a text handler, then writes each one
+// in the color of its level.
+type colorHandler struct {
+	slog.Handler
+	mu  *sync.Mutex
+	buf *bytes.Buffer
+	out *hue.Writer
+}
+
+func (h *colorHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.buf.Reset()
+	if err := h.Handler.Handle(ctx, record); err != nil {
+		return err
+	}
+	h.out.SetHue(levelHue(record.Level))
+	_, err := h.out.WriteString(h.buf.String())
+	return err
+}
+
+func (h *colorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &colorHandler{h.Handler.WithAttrs(attrs), h.mu, h.buf, h.out}
+}
+
+func (h *colorHandler) WithGroup(name string) slog.Handler {
+	return &colorHandler{h.Handler.WithGroup(name), h.mu, h.buf, h.out}
+}
+
+func levelHue(level slog.Level) *hue.Hue {
+	switch {
+	case level >= slog.LevelError:
+		return hue.New(hue.Red, hue.Default)
+	case level >= slog.LevelWarn:
+		return hue.New(hue.Brown, hue.Default)
+	case level >= slog.LevelInfo:
+		return hue.New(hue.Green, hue.Default)
+	default:
+		return hue.New(hue.Blue, hue.Default)
+	}
+}
+
+// fatal logs an error and exits.
+func fatal(logger *slog.Logger, message string, err error) {
+	logger.Error(message, "error", err)
+	os.Exit(1)
+}
@@ -1,20 +1,20 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/as/hue"
-	prometheusmetrics "github.com/deathowl/go-metrics-prometheus"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/rcrowley/go-metrics"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 
 	prometheuslog "github.com/keithknott26/prometheuslog/pkg/app"
@@ -28,61 +28,72 @@ var (
 	metricsFlushInterval = app.Flag("flush-interval", "How often to flush available metrics: (1s,5s,15s,1h,etc) (default: 2s) ...)").Short('f').Default("2s").Duration()
 	maxIngestionRate     = app.Flag("max-ingestion-rate", "Ingestion Rate Limiter:(1000,5000,10000,etc) in operations per/sec (default: 10000) ...)").Short('r').Default("10000").Int()
 	configFile           = app.Flag("config-file", "Full path to the prometheuslog.conf config file.\n").Short('c').ExistingFile()
-)
+	stdinApplication     = app.Flag("stdin", "Read standard input as the log of this application, e.g. kubectl logs -f mypod | prometheuslog --stdin mypod.").PlaceHolder("APPLICATION").String()
+	syslogListen         = app.Flag("syslog-listen", "Receive syslog messages on this address: udp://:514, tcp://:514 or unix:///path/to/socket; repeatable. Applications select messages with a syslog: log path.").Strings()
+	ingestTokens         = app.Flag("ingest-token", "Bearer token accepted by POST /ingest/<application>, for applications with the log path http:, and by /debug/explain; repeatable.").Envar("PROMETHEUSLOG_INGEST_TOKEN").Strings()
+	explainEndpoint      = app.Flag("explain-endpoint", "Serve /debug/explain on the metrics port, for requests with an --ingest-token bearer token.").Bool()
+	kubernetesPodLogs    = app.Flag("kubernetes-pod-logs", "Discover container logs in this pod log directory, usually /var/log/pods, with one application per container.").String()
+	kubernetesFormat     = app.Flag("kubernetes-format", "Format of discovered container logs: cri or docker.").Default("cri").Enum("cri", "docker")
+	watchMode            = app.Flag("watch", "How log files are watched: auto (inotify, polling on network and overlay filesystems or when inotify is unavailable), inotify or poll.").Default("auto").Enum("auto", "inotify", "poll")
+	pollInterval         = app.Flag("poll-interval", "How often polled log files are checked.").Default("250ms").Duration()
+	levelField           = app.Flag("level-field", "Whitespace separated word of each line holding its level, from 1, for log_messages_total. Default: a JSON level field, a level= pair, a bracketed level, or the first word after the timestamp.").Int()
+	levelRegex           = app.Flag("level-regex", "Regex whose level (or first) group is the level of each line, e.g. \"level\":\"(\\w+)\" for JSON logs.").String()
+	levelAliases         = app.Flag("level-alias", "Another spelling of a level (trace, debug, info, warn, error or fatal) as alias=level, e.g. E=error; repeatable.").PlaceHolder("ALIAS=LEVEL").StringMap()
+	rulesFile            = app.Flag("rules-file", "Full path to a JSON file of declarative rules (optional).").Short('R').ExistingFile()
 
-type property struct {
-	name string
-	log  string
-}
+	snapshotDir          = app.Flag("snapshot-dir", "Directory to write periodic metric snapshots to, one file per application. With --debug, defaults to the current directory.").String()
+	snapshotFormat       = app.Flag("snapshot-format", "Snapshot format: prometheus or json.").Default("prometheus").Enum("prometheus", "json")
+	snapshotInterval     = app.Flag("snapshot-interval", "How often to write a snapshot: (30s,1m,5m,etc)").Default("60s").Duration()
+	snapshotMaxSize      = app.Flag("snapshot-max-size", "Rotate a snapshot file when it reaches this size (10MB, 1GB, etc). 0 disables.").Default("0").Bytes()
+	snapshotMaxAge       = app.Flag("snapshot-max-age", "Rotate a snapshot file after this long (1h, 24h, etc). 0 disables.").Default("0s").Duration()
+	snapshotMaxBackups   = app.Flag("snapshot-max-backups", "Rotated snapshot files to keep per application. 0 keeps all.").Default("0").Int()
+	textfileDir          = app.Flag("textfile-dir", "Write metrics to a .prom file in this node_exporter textfile directory every flush interval, instead of listening on a port.").ExistingDir()
+	textfileName         = app.Flag("textfile-name", "File name to use in --textfile-dir.").Default("prometheuslog.prom").String()
+	pushURL              = app.Flag("push-url", "Push metrics to this Prometheus Pushgateway URL every flush interval, instead of listening on a port.").String()
+	pushJob              = app.Flag("push-job", "Pushgateway job label.").Default("prometheuslog").String()
+	pushGrouping         = app.Flag("push-grouping", "Pushgateway grouping label as name=value; repeatable. Default: instance=<hostname>").StringMap()
+	pushRetries          = app.Flag("push-retries", "Retries after a failed push, with exponential backoff.").Default("3").Int()
+	remoteWriteURL       = app.Flag("remote-write-url", "Also send samples to this Prometheus remote_write endpoint every flush interval.").String()
+	remoteWriteLabels    = app.Flag("remote-write-label", "External label added to every remote_write series as name=value; repeatable.").StringMap()
+	remoteWriteBufferDir = app.Flag("remote-write-buffer-dir", "Directory to buffer remote_write requests in while the endpoint is unreachable.").String()
+	remoteWriteBatchSize = app.Flag("remote-write-batch-size", "Samples per remote_write request.").Default("500").Int()
+	otlpEndpoint         = app.Flag("otlp-endpoint", "Also export metrics to this OpenTelemetry collector (host:port or URL) every flush interval.").String()
+	otlpProtocol         = app.Flag("otlp-protocol", "OTLP protocol: grpc or http.").Default("grpc").Enum("grpc", "http")
+	otlpInsecure         = app.Flag("otlp-insecure", "Use plaintext instead of TLS for OTLP.").Bool()
+	otlpHeaders          = app.Flag("otlp-header", "Header sent with every OTLP export as name=value; repeatable.").StringMap()
+	statsdAddress        = app.Flag("statsd-address", "Also send every counter increment and gauge update to this StatsD server (host:port) over UDP.").String()
+	statsdFlavor         = app.Flag("statsd-flavor", "StatsD flavor: statsd, or dogstatsd to send the application and environment as tags.").Default("statsd").Enum("statsd", "dogstatsd")
+	statsdPrefix         = app.Flag("statsd-prefix", "Prefix for every StatsD metric name.").String()
+	statsdTags           = app.Flag("statsd-tag", "DogStatsD tag added to every metric as name=value; repeatable.").StringMap()
+	statsdMaxPacketSize  = app.Flag("statsd-max-packet-size", "Largest StatsD UDP packet in bytes; updates are batched up to this size.").Default("1432").Int()
+	graphiteAddress      = app.Flag("graphite-address", "Also send metrics to this Graphite plaintext listener (host:port) over TCP.").String()
+	graphitePrefix       = app.Flag("graphite-prefix", "Graphite metric path prefix; {application} and {environment} are replaced.").Default("{application}.{environment}").String()
+	graphiteInterval     = app.Flag("graphite-interval", "How often to send metrics to Graphite (default: the flush interval).").Duration()
+	influxDBURL          = app.Flag("influxdb-url", "Also write metrics to this InfluxDB line protocol endpoint, e.g. http://influxdb:8086/write?db=metrics.").String()
+	influxDBToken        = app.Flag("influxdb-token", "InfluxDB API token.").Envar("INFLUXDB_TOKEN").String()
+	influxDBPrefix       = app.Flag("influxdb-prefix", "Prefix for every InfluxDB measurement name.").String()
+	influxDBTags         = app.Flag("influxdb-tag", "Tag added to every InfluxDB point as name=value; repeatable.").StringMap()
+	influxDBInterval     = app.Flag("influxdb-interval", "How often to write metrics to InfluxDB (default: the flush interval).").Duration()
 
-func readCSVFile(fileName string) ([][]string, error) {
-	file, err := os.Open(fileName)
-	if err != nil {
-		log.Fatal(fmt.Sprintf("Failed to open the conf file: %s\n", fileName))
-	}
-	r := csv.NewReader(file)
-	lines, err := r.ReadAll()
-	if err != nil {
-		log.Fatal("Failed to parse the provided conf file.")
-	}
-	return lines, err
-}
+	runCommand = app.Command("run", "Tail the configured logs and expose /metrics (default).").Default()
+)
 
-func parseCSVLines(lines [][]string) []property {
-	ret := make([]property, len(lines))
-	for i, line := range lines {
-		ret[i] = property{
-			name: line[0],
-			log:  line[1],
+func serveEndpoint(App *prometheuslog.App, logger *slog.Logger) {
+	http.Handle("/metrics", promhttp.Handler())
+	if len(*ingestTokens) > 0 {
+		http.Handle("/ingest/", App.IngestHandler())
+	}
+	if *explainEndpoint {
+		if len(*ingestTokens) == 0 {
+			logger.Warn("/debug/explain refuses every request without an --ingest-token")
 		}
+		http.Handle("/debug/explain", App.ExplainHandler())
 	}
-	return ret
-}
-func serveEndpoint() {
-	http.Handle("/metrics", promhttp.Handler())
 	portNumber := strconv.Itoa(*port)
 	portStr := fmt.Sprintf(":%s", portNumber)
-	green := hue.New(hue.Green, hue.Default)
-	hw := hue.NewWriter(os.Stdout, green)
-	hw.SetHue(green)
-	hw.WriteString(fmt.Sprintf("Listening for /metrics requests on port %s\n", portStr))
-	log.Fatal(http.ListenAndServe(portStr, nil))
-}
-func enableMetricsLogging(applicationName string, registry metrics.Registry, intervalSec time.Duration) {
-	/*         Metric Logging              */
-	/*  uncomment to log metrics to a file */
-	filename := fmt.Sprintf("%s.metrics.log", applicationName)
-	logfile, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		log.Fatalf("error opening file: %v", err)
-	}
-	//defer logfile.Close()
-
-	log.SetOutput(logfile)
-	title := fmt.Sprintf("%s:\t", applicationName)
-	go metrics.Log(registry, intervalSec, log.New(logfile, title, log.Lmicroseconds))
+	logger.Info("listening for /metrics requests", "address", portStr)
+	fatal(logger, "metrics endpoint stopped", http.ListenAndServe(portStr, nil))
 }
-
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	if os.IsNotExist(err) {
@@ -114,110 +125,190 @@ func main() {
 
 	// Parse args and assign values
 	kingpin.Version("0.0.1")
-	kingpin.MustParse(app.Parse(os.Args[1:]))
-
-	//assign colors used in text output
-	red := hue.New(hue.Red, hue.Default)
-	green := hue.New(hue.Green, hue.Default)
-	blue := hue.New(hue.Blue, hue.Default)
-	yellow := hue.New(hue.Brown, hue.Default)
-	magenta := hue.New(hue.Magenta, hue.Default)
-	// Print a green string with a hue.Writer
-	hw := hue.NewWriter(os.Stdout, green)
-
-	// create new application
-	App := prometheuslog.NewApp()
+	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
+	case testCommand.FullCommand():
+		os.Exit(replayLog())
+	case explainCommand.FullCommand():
+		os.Exit(explainLines())
+	case checkCommand.FullCommand():
+		os.Exit(checkConfig())
+	case backfillCommand.FullCommand():
+		os.Exit(backfillLogs())
+	default:
+		run()
+	}
+}
 
-	//whether or not to enable debug messages
-	var debugEnabled bool
-	if *debug == true {
-		debugEnabled = true
-	} else {
-		debugEnabled = false
+// loadConfig builds the library configuration from the flags and the rules
+// file. Applications are added by the caller.
+func loadConfig() (prometheuslog.Config, error) {
+	config := prometheuslog.Config{
+		Environment:      *environment,
+		FlushInterval:    *metricsFlushInterval,
+		MaxIngestionRate: *maxIngestionRate,
+		Debug:            *debug,
+		Snapshot: prometheuslog.SnapshotConfig{
+			Directory:  *snapshotDir,
+			Format:     *snapshotFormat,
+			Interval:   *snapshotInterval,
+			MaxSize:    int64(*snapshotMaxSize),
+			MaxAge:     *snapshotMaxAge,
+			MaxBackups: *snapshotMaxBackups,
+		},
+	}
+	if *textfileDir != "" {
+		// a path would put the file outside --textfile-dir
+		if filepath.Base(*textfileName) != *textfileName || *textfileName == "." || *textfileName == ".." {
+			return config, fmt.Errorf("--textfile-name %q is not a file name", *textfileName)
+		}
+		config.Textfile = filepath.Join(*textfileDir, *textfileName)
+	}
+	if *pushURL != "" {
+		config.Push = prometheuslog.PushConfig{
+			URL:      *pushURL,
+			Job:      *pushJob,
+			Grouping: *pushGrouping,
+			Retries:  *pushRetries,
+		}
+	}
+	if *remoteWriteURL != "" {
+		config.RemoteWrite = prometheuslog.RemoteWriteConfig{
+			URL:             *remoteWriteURL,
+			ExternalLabels:  *remoteWriteLabels,
+			BufferDirectory: *remoteWriteBufferDir,
+			BatchSize:       *remoteWriteBatchSize,
+		}
+	}
+	if *otlpEndpoint != "" {
+		config.OTLP = prometheuslog.OTLPConfig{
+			Endpoint: *otlpEndpoint,
+			Protocol: *otlpProtocol,
+			Insecure: *otlpInsecure,
+			Headers:  *otlpHeaders,
+		}
+	}
+	if *statsdAddress != "" {
+		config.StatsD = prometheuslog.StatsDConfig{
+			Address:       *statsdAddress,
+			Flavor:        *statsdFlavor,
+			Prefix:        *statsdPrefix,
+			Tags:          *statsdTags,
+			MaxPacketSize: *statsdMaxPacketSize,
+		}
+	}
+	if *graphiteAddress != "" {
+		config.Graphite = prometheuslog.GraphiteConfig{
+			Address:  *graphiteAddress,
+			Prefix:   *graphitePrefix,
+			Interval: *graphiteInterval,
+		}
+	}
+	if *influxDBURL != "" {
+		config.InfluxDB = prometheuslog.InfluxDBConfig{
+			URL:      *influxDBURL,
+			Token:    *influxDBToken,
+			Prefix:   *influxDBPrefix,
+			Tags:     *influxDBTags,
+			Interval: *influxDBInterval,
+		}
+	}
+	config.Watch = *watchMode
+	config.PollInterval = *pollInterval
+	config.Level = prometheuslog.LevelConfig{Field: *levelField, Regex: *levelRegex, Aliases: *levelAliases}
+	config.Syslog.Listen = *syslogListen
+	config.IngestTokens = *ingestTokens
+	if *kubernetesPodLogs != "" {
+		config.Kubernetes = prometheuslog.KubernetesConfig{
+			LogDirectory: *kubernetesPodLogs,
+			Format:       *kubernetesFormat,
+		}
+	}
+	if *rulesFile != "" {
+		rules, err := prometheuslog.ReadRulesFile(*rulesFile)
+		if err != nil {
+			return config, err
+		}
+		config.Rules = rules
 	}
-	//set the max ingestion rate
-	maxRate := *maxIngestionRate
+	return config, nil
+}
+
+func run() {
+	logger := newLogger()
 
 	//check if config file is specified
-	if *configFile == "" {
-		fmt.Println("You did not specify a config file, exiting...")
+	if *configFile == "" && *stdinApplication == "" && *kubernetesPodLogs == "" {
+		logger.Error("you did not specify a config file, --stdin or --kubernetes-pod-logs, exiting...")
 		os.Exit(1)
-	} else {
+	}
+	var instances []prometheuslog.ApplicationConfig
+	if *configFile != "" {
 		// start CSV file processing
-		hw.WriteString("Parsing config file...\n")
-		lines, err := readCSVFile(*configFile)
+		logger.Info("parsing config file", "file", *configFile)
+		var err error
+		instances, err = prometheuslog.ReadConfigFile(*configFile)
 		if err != nil {
-			hw.SetHue(red)
-			hw.WriteString("Error locating prometheuslog.conf, did you provide the full path?")
-		}
-		//parse config file into individual instances
-		instances := parseCSVLines(lines)
-		hw.WriteString("Creating objects and applying metrics configuration..\n")
-		for id, app := range instances {
-
-			if _, err := os.Stat(app.log); err == nil {
-				hw.SetHue(green)
-				hw.WriteString("Adding:  ")
-				hw.SetHue(red)
-				hw.WriteString("ID: ")
-				hw.SetHue(yellow)
-				hw.WriteString(fmt.Sprintf("%d", id))
-				hw.SetHue(blue)
-				hw.WriteString("\tInstance Name: ")
-				hw.SetHue(magenta)
-				hw.WriteString(fmt.Sprintf("%s", app.name))
-				hw.SetHue(blue)
-				hw.WriteString("\tLog: ")
-				hw.SetHue(yellow)
-				hw.WriteString(fmt.Sprintf("%s\n", app.log))
-				/*
-					Variables from Config file:
-					applications.name
-					applications.log
-				*/
-
-				Application := App.AddApplication(id, app.name, app.log, maxRate, debugEnabled)
-				if debugEnabled == true {
-					enableMetricsLogging(app.name, Application.MetricsRegistry, 60*time.Second)
-				}
-
-				//sleep for a second
-				time.Sleep(1 * time.Second)
-
-				pClient := prometheusmetrics.NewPrometheusProvider(Application.MetricsRegistry, app.name, *environment, prometheus.DefaultRegisterer, *metricsFlushInterval)
-				go pClient.UpdatePrometheusMetrics()
-
-			} else if os.IsNotExist(err) {
-				hw.SetHue(red)
-				hw.WriteString(fmt.Sprintf("Skipping (file doesn't exist): %s", app.name))
-			} else {
-				hw.SetHue(red)
-				hw.WriteString(fmt.Sprintf("Skipping: %s", app.name))
-			}
+			fatal(logger, "unable to read config file", err)
 		}
 	}
-	hw.SetHue(green)
-	hw.WriteString(fmt.Sprintf("\n\nService Started...\n"))
-	go serveEndpoint()
+	if *stdinApplication != "" {
+		instances = append(instances, prometheuslog.ApplicationConfig{Name: *stdinApplication, LogPath: prometheuslog.StdinPath})
+	}
+	config, err := loadConfig()
+	if err != nil {
+		fatal(logger, "unable to read rules file", err)
+	}
+	config.Logger = logger
+	if config.Debug == true && config.Snapshot.Directory == "" {
+		config.Snapshot.Directory = "."
+	}
 
-	/* Gracefully exit the program */
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		select {
-		case sig := <-c:
-			hw.SetHue(red)
-			hw.WriteString(fmt.Sprintf("Got %s signal. Aborting service sanely...\n", sig))
-			os.Exit(1)
-		}
-	}()
-
-	metricsInterval := 30 * time.Second
-	tick := time.Tick(metricsInterval)
-	for {
-		select {
-		case <-tick:
-			fmt.Printf(".")
+	logger.Info("creating objects and applying metrics configuration")
+	for id, app := range instances {
+
+		if app.LogPath == prometheuslog.StdinPath || app.LogPath == prometheuslog.IngestPath || strings.HasPrefix(app.LogPath, prometheuslog.SyslogPathPrefix) {
+			logger.Info("adding application", "id", id, "application", app.Name, "log", app.LogPath)
+			config.Applications = append(config.Applications, app)
+		} else if _, err := os.Stat(app.LogPath); err == nil {
+			logger.Info("adding application", "id", id, "application", app.Name, "log", app.LogPath)
+			config.Applications = append(config.Applications, app)
+		} else if os.IsNotExist(err) {
+			logger.Warn("skipping application, log doesn't exist", "application", app.Name, "log", app.LogPath)
+		} else {
+			logger.Warn("skipping application", "application", app.Name, "log", app.LogPath, "error", err)
 		}
 	}
+
+	// create new application
+	App, err := prometheuslog.New(config)
+	if err != nil {
+		fatal(logger, "invalid configuration", err)
+	}
+	prometheus.MustRegister(App.Collector())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := App.Start(ctx); err != nil {
+		fatal(logger, "unable to start", err)
+	}
+
+	logger.Info("service started")
+	switch {
+	case config.Textfile != "":
+		logger.Info("writing metrics to textfile", "file", config.Textfile, "interval", config.FlushInterval.String())
+	case config.Push.URL != "":
+		logger.Info("pushing metrics to pushgateway", "url", config.Push.URL, "job", config.Push.Job)
+	default:
+		go serveEndpoint(App, logger)
+	}
+
+	/* Gracefully exit the program */
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	sig := <-c
+	logger.Warn("aborting service sanely", "signal", sig.String())
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	App.Stop(shutdownCtx)
+	shutdownCancel()
+	os.Exit(1)
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	prometheuslog "github.com/keithknott26/prometheuslog/pkg/app"
+)
+
+var (
+	testCommand     = app.Command("test", "Replay a log file through the rules and print the resulting metrics.")
+	testLog         = testCommand.Arg("log", "Log file to replay from the beginning, or - for stdin.").Default("-").String()
+	testApplication = testCommand.Flag("application", "Application name the lines belong to.").Short('a').Default("test").String()
+	testFormat      = testCommand.Flag("format", "Log format to unwrap: docker or cri.").Enum("docker", "cri")
+	testExpected    = testCommand.Flag("expected", "Compare the output with this file and exit non-zero on differences.").ExistingFile()
+)
+
+// replayLog implements the test command and returns the exit code.
+func replayLog() int {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	config.Applications = withApplication(config.Applications, *testApplication, *testFormat)
+	App, err := prometheuslog.New(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var r io.Reader = os.Stdin
+	if *testLog != "-" {
+		file, err := os.Open(*testLog)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		r = file
+	}
+
+	result, err := App.Replay(*testApplication, r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	actual := result.String()
+	fmt.Print(actual)
+
+	if *testExpected == "" {
+		return 0
+	}
+	data, err := os.ReadFile(*testExpected)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if diff := diffLines(string(data), actual); diff != "" {
+		fmt.Fprintf(os.Stderr, "\nOutput differs from %s:\n%s", *testExpected, diff)
+		return 1
+	}
+	return 0
+}
+
+// withApplication returns applications with the named one, added unless
+// it is already defined. A format, when given, replaces its format.
+func withApplication(applications []prometheuslog.ApplicationConfig, name string, format string) []prometheuslog.ApplicationConfig {
+	for i := range applications {
+		if applications[i].Name == name {
+			if format != "" {
+				applications[i].Format = format
+			}
+			return applications
+		}
+	}
+	return append(applications, prometheuslog.ApplicationConfig{Name: name, Format: format})
+}
+
+// diffLines lists the lines missing from actual (-) and the unexpected
+// lines in actual (+). Blank lines are ignored.
+func diffLines(expected string, actual string) string {
+	count := func(s string) map[string]int {
+		lines := map[string]int{}
+		for _, line := range strings.Split(s, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines[line]++
+			}
+		}
+		return lines
+	}
+	expectedLines, actualLines := count(expected), count(actual)
+
+	var diff strings.Builder
+	for _, line := range strings.Split(expected, "\n") {
+		line = strings.TrimSpace(line)
+		if expectedLines[line] > 0 && actualLines[line] == 0 {
+			fmt.Fprintf(&diff, "- %s\n", line)
+		}
+	}
+	for _, line := range strings.Split(actual, "\n") {
+		line = strings.TrimSpace(line)
+		if actualLines[line] > 0 && expectedLines[line] == 0 {
+			fmt.Fprintf(&diff, "+ %s\n", line)
+		}
+	}
+	return diff.String()
+}
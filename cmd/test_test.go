@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	prometheuslog "github.com/keithknott26/prometheuslog/pkg/app"
+)
+
+const replayLogLines = `2019-12-28 00:44:45,714 payment accepted amount=5
+2019-12-28 00:44:46,020 payment accepted amount=7
+2019-12-28 00:44:47,301 payment declined
+`
+
+func replayPayments(t *testing.T) string {
+	t.Helper()
+	App, err := prometheuslog.New(prometheuslog.Config{
+		Environment: "prod",
+		Rules: []prometheuslog.Rule{
+			{Name: "accepted", Contains: "payment accepted", Metric: "payments-accepted-total"},
+			{Name: "amount", Contains: "payment accepted", Regex: `amount=([0-9]+)`, Metric: "payment-amount", Type: prometheuslog.RuleTypeGauge, Value: "1"},
+			{Name: "refunds", Contains: "refund", Metric: "refunds-total"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := App.Replay("payments", strings.NewReader(replayLogLines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.String()
+}
+
+func TestReplayExpected(t *testing.T) {
+	expected := `lines 3
+metric payments_prod_apm_log_read_rate 3
+metric payments_prod_payment_amount 7
+metric payments_prod_payments_accepted_total 2
+rule accepted 2
+rule amount 2
+rule refunds 0
+`
+	actual := replayPayments(t)
+	if actual != expected {
+		t.Fatalf("got:\n%s\nwant:\n%s", actual, expected)
+	}
+	// the expected file may be reordered and have blank lines
+	if diff := diffLines("rule refunds 0\n\n"+expected, actual); diff != "" {
+		t.Errorf("expected no differences, got:\n%s", diff)
+	}
+}
+
+func TestReplayExpectedMismatch(t *testing.T) {
+	expected := `lines 3
+metric payments_prod_apm_log_read_rate 3
+metric payments_prod_payment_amount 5
+metric payments_prod_payments_accepted_total 2
+rule accepted 2
+rule amount 2
+`
+	want := `- metric payments_prod_payment_amount 5
++ metric payments_prod_payment_amount 7
++ rule refunds 0
+`
+	if diff := diffLines(expected, replayPayments(t)); diff != want {
+		t.Errorf("got:\n%s\nwant:\n%s", diff, want)
+	}
+}
+
+func TestWithApplication(t *testing.T) {
+	defined := []prometheuslog.ApplicationConfig{{Name: "payments", LogPath: "/var/log/payments.log"}}
+	applications := withApplication(defined, "payments", "cri")
+	if len(applications) != 1 || applications[0].Format != "cri" || applications[0].LogPath != "/var/log/payments.log" {
+		t.Errorf("defined application: got %+v", applications)
+	}
+	applications = withApplication(defined, "orders", "")
+	if len(applications) != 2 || applications[1].Name != "orders" {
+		t.Errorf("new application: got %+v", applications)
+	}
+}
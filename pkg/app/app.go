@@ -1,14 +1,16 @@
 package prometheuslog
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	prometheusmetrics "github.com/deathowl/go-metrics-prometheus"
-	"github.com/papertrail/go-tail/follower"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rcrowley/go-metrics"
 	"go.uber.org/ratelimit"
@@ -20,26 +22,60 @@ var (
 
 type App struct {
 	sync.Mutex
+	Config               Config
 	MetricsShipFrequency int
 	TotalLinesRead       int
 	LogTimeDifference    string
-	Applications         []Application
+	Applications         []*Application
+
+	rules        []Rule
+	lineHandlers map[string][]LineHandler
+	levels       *levelMatcher // for applications without their own
+	collector    *collector
+	statsd       *statsdClient
+	syslog       *syslogReceiver
+	files        *fileMetrics
+	watcher      *fileWatcher
+	discovered   map[string]*Application // by container log directory
+	nextID       int                     // ID of the next discovered application
+	cancel       context.CancelFunc
+	stopCtx      context.Context // given to Stop, bounding final sends
+	wg           sync.WaitGroup
 }
 
 type Application struct {
-	App
+	*App
 	sync.Mutex
 	ID                 int
 	TotalLinesRead     int
 	ReadRate           int
 	LogTimeDifference  string
 	ApplicationName    string
-	LogPath            string
-	LogFollower        *follower.Follower
+	LogPath            string            // a file, a named pipe, StdinPath, IngestPath, or a SyslogPathPrefix selector
+	Input              Input             // set by Start
+	Format             string            // FormatDocker or FormatCRI to unwrap container log lines
+	Watch              string            // WatchAuto, WatchInotify or WatchPoll; Config.Watch when empty
+	PollInterval       time.Duration     // Config.PollInterval when zero
+	Labels             map[string]string // when set, metrics are exported with these labels; also fields of every line
 	MetricsRegistry    metrics.Registry
 	PrometheusRegistry *prometheus.Registry
 	PrometheusConfig   *prometheusmetrics.PrometheusConfig
 	DebugEnabled       bool
+
+	handlers       []LineHandler
+	ruleMatches    []int64          // per rule in App.rules
+	statsdRegistry metrics.Registry // MetricsRegistry, also sending updates to statsd
+	ingest         *ingestInput     // the input lines are posted to, under Input's decoder if any
+	logger         *slog.Logger
+
+	levels           *levelMatcher // App.levels when nil
+	levelCounts      levelCounts
+	levelCollector   prometheus.Collector
+	fromStart        bool                 // read the log from the beginning instead of the end
+	metricsName      string               // replaces ApplicationName in metric names
+	cancel           context.CancelFunc   // stops a discovered application
+	labeledCollector prometheus.Collector // exports the metrics of an application with Labels
+	flushLock        sync.Mutex           // serializes updates of PrometheusConfig, which isn't safe for concurrent use
 }
 
 type prometheusConfig struct {
@@ -52,101 +88,432 @@ type prometheusConfig struct {
 }
 
 func NewApp() *App {
-	return &App{
-		Applications: []Application{},
+	app := &App{
+		Applications: []*Application{},
+		collector:    &collector{},
+	}
+	app.Config.setDefaults()
+	app.levels, _ = LevelConfig{}.compile()
+	return app
+}
+
+// New creates an App from config, adding every configured application and
+// compiling every rule. Call Start to begin tailing the logs.
+func New(config Config) (*App, error) {
+	config.setDefaults()
+	if problems := config.validate(); len(problems) > 0 {
+		return nil, problems[0]
 	}
+	levels, err := config.Level.compile()
+	if err != nil {
+		return nil, err
+	}
+	app := NewApp()
+	app.Config = config
+	app.levels = levels
+	for _, rule := range config.Rules {
+		if err := app.AddRule(rule); err != nil {
+			return nil, err
+		}
+	}
+	for id, application := range config.Applications {
+		added := app.AddApplication(id, application.Name, application.LogPath, config.MaxIngestionRate, config.Debug)
+		added.Format, added.Watch, added.PollInterval = application.Format, application.Watch, application.PollInterval
+		if application.Level.Field != 0 || application.Level.Regex != "" || len(application.Level.Aliases) > 0 {
+			if added.levels, err = config.Level.levelFor(application.Level).compile(); err != nil {
+				return nil, fmt.Errorf("application %q: %v", application.Name, err)
+			}
+		}
+	}
+	return app, nil
 }
 
-func NewApplication(app *App, id int, applicationName string) Application {
-	application := Application{
+func NewApplication(app *App, id int, applicationName string) *Application {
+	application := &Application{
+		App:               app,
 		ID:                id,
 		ApplicationName:   applicationName,
 		TotalLinesRead:    0,
 		ReadRate:          0,
 		LogTimeDifference: "",
+		logger:            app.Config.Logger.With("application", applicationName),
 	}
 	return application
 }
 func (app *App) GetApplication(i int) *Application {
 	app.Lock()
 	defer app.Unlock()
-	return &app.Applications[i] //CHANGED
+	return app.Applications[i]
 }
 
-func (app *App) AddApplication(id int, applicationName string, logPath string, maxRate int, debugEnabled bool) Application {
+// AddApplication registers a log to tail. Its follower and worker are
+// started by Start.
+func (app *App) AddApplication(id int, applicationName string, logPath string, maxRate int, debugEnabled bool) *Application {
 	app.Lock()
 	defer app.Unlock()
 
 	application := NewApplication(app, id, applicationName)
-	application.ID = id
-	application.LogFollower = application.createFollower(logPath)
+	application.LogPath = logPath
+	application.ReadRate = maxRate
 	application.MetricsRegistry = application.createRegistry(applicationName)
-	application.PrometheusConfig = prometheusmetrics.NewPrometheusProvider(application.MetricsRegistry, applicationName, "subsys", prometheus.DefaultRegisterer, 1*time.Second)
 	application.DebugEnabled = debugEnabled
-	go application.queueWorker(application.LogFollower, maxRate)
+	app.Applications = append(app.Applications, application)
+	app.nextID = max(app.nextID, id+1)
+	return application
+}
 
-	if application.DebugEnabled == true {
-		loggingInterval := 60 * time.Second
-		application.enableLogging(applicationName, application.MetricsRegistry, loggingInterval)
+// AddRule compiles a declarative rule, or wraps a RuleFunc, and runs it on
+// every log line after CategorizeLogData. Rules must be added before Start.
+func (app *App) AddRule(rule Rule) error {
+	if err := rule.compile(); err != nil {
+		return err
 	}
-	return application
+	app.Lock()
+	defer app.Unlock()
+	for _, existing := range app.rules {
+		if rule.Name != "" && existing.Name == rule.Name {
+			return fmt.Errorf("rule %q: duplicate name", rule.Name)
+		}
+	}
+	app.rules = append(app.rules, rule)
+	return nil
 }
 
-func (app *App) writeDebugMessage(debug bool, message string, applicationName string) {
-	if debug == true {
-		debugline := fmt.Sprintf("     %s ---------> %s", applicationName, message)
-		fmt.Println(debugline)
+// RegisterRule adds a custom Go rule. It must be called before Start.
+func (app *App) RegisterRule(name string, fn RuleFunc) error {
+	if fn == nil {
+		return fmt.Errorf("rule %q: func is nil", name)
 	}
+	return app.AddRule(Rule{Name: name, Func: fn})
 }
 
-func (application *Application) createFollower(logPath string) *follower.Follower {
-	logFollower, err := follower.New(logPath, follower.Config{
-		Whence: io.SeekEnd,
-		Offset: 0,
-		Reopen: true,
-	})
-	fmt.Println(fmt.Sprintf("Attaching to: %s", logPath))
-	if logFollower.Err() != nil {
-		log.Println(logFollower.Err())
+// Start attaches to every application's log and begins updating metrics.
+// It returns immediately; everything stops when ctx is done or Stop is called.
+func (app *App) Start(ctx context.Context) error {
+	app.Lock()
+	defer app.Unlock()
+	if app.cancel != nil {
+		return errors.New("prometheuslog: app already started")
+	}
+	ctx, app.cancel = context.WithCancel(ctx)
+	// on failure, wait for the workers already started to stop, without the
+	// App lock they may need, and leave the App as it was so Start can be
+	// called again
+	registered := app.collector.registered()
+	fail := func(err error) error {
+		app.cancel()
+		app.Unlock()
+		app.wg.Wait()
+		app.Lock()
+		app.cancel = nil
+		app.statsd, app.syslog, app.files, app.watcher = nil, nil, nil, nil
+		for _, application := range app.Applications {
+			application.statsdRegistry = nil
+		}
+		app.collector.unregisterFrom(registered)
+		return err
+	}
+	app.files = newFileMetrics(app)
+	app.watcher = newFileWatcher(ctx, app.Config.Logger)
+
+	if app.Config.StatsD.Address != "" {
+		statsd, err := newStatsdClient(app)
+		if err != nil {
+			return fail(err)
+		}
+		app.statsd = statsd
+		app.wg.Add(1)
+		go app.statsdWorker(ctx, statsd)
+	}
+
+	if len(app.Config.Syslog.Listen) > 0 {
+		syslog, err := newSyslogReceiver(ctx, app)
+		if err != nil {
+			return fail(err)
+		}
+		app.syslog = syslog
+	}
+
+	for _, application := range app.Applications {
+		application.prepare()
+		if err := app.startApplication(ctx, application); err != nil {
+			return fail(err)
+		}
+	}
+	if app.Config.Kubernetes.LogDirectory != "" {
+		app.wg.Add(1)
+		go app.discoveryWorker(ctx)
+	}
+	if app.Config.Textfile != "" {
+		app.wg.Add(1)
+		go app.textfileWorker(ctx)
+	}
+	if app.Config.Push.URL != "" {
+		app.wg.Add(1)
+		go app.pushWorker(ctx)
 	}
+	if app.Config.RemoteWrite.URL != "" {
+		app.wg.Add(1)
+		go app.remoteWriteWorker(ctx)
+	}
+	if app.Config.Graphite.Address != "" {
+		app.wg.Add(1)
+		go app.graphiteWorker(ctx)
+	}
+	if app.Config.InfluxDB.URL != "" {
+		app.wg.Add(1)
+		go app.influxDBWorker(ctx)
+	}
+	return nil
+}
+
+// startApplication attaches to an application's log and starts its
+// workers, which stop when ctx is done. The application must be prepared.
+// The caller must hold the App lock, unless the application isn't in
+// App.Applications yet.
+func (app *App) startApplication(ctx context.Context, application *Application) error {
+	application.logger.Info("attaching to log", "log", application.LogPath)
+	input, err := application.openInput()
 	if err != nil {
-		log.Println(err)
+		return fmt.Errorf("prometheuslog: unable to attach to %s: %v", application.LogPath, err)
+	}
+	_, isFile := input.(*fileInput)
+	application.ingest, _ = input.(*ingestInput)
+	if application.Format != "" {
+		input = newDecoderInput(input, application.Format)
+	}
+	application.Input = input
+	if app.statsd != nil {
+		application.statsdRegistry = app.statsd.newStatsdRegistry(application.MetricsRegistry, application.ApplicationName, app.Config.Environment)
+	}
+
+	name := application.exportedName()
+	application.levelCollector = newLevelCollector(&application.levelCounts, name, app.Config.Environment, application.Labels)
+	app.collector.MustRegister(application.levelCollector)
+	if len(application.Labels) > 0 {
+		application.labeledCollector = newRegistryCollector(application.MetricsRegistry, name, app.Config.Environment, application.Labels)
+		app.collector.MustRegister(application.labeledCollector)
+		app.wg.Add(1)
+	} else {
+		application.PrometheusConfig = prometheusmetrics.NewPrometheusProvider(application.MetricsRegistry, name, app.Config.Environment, app.collector, app.Config.FlushInterval)
+		app.wg.Add(2)
+		go application.flushWorker(ctx)
+	}
+	go application.queueWorker(ctx, application.Input, application.ReadRate)
+	if isFile {
+		app.wg.Add(1)
+		go application.fileStatsWorker(ctx)
+	}
+
+	if app.Config.Snapshot.Directory != "" {
+		app.wg.Add(1)
+		go application.snapshotWorker(ctx)
+	}
+	if app.Config.OTLP.Endpoint != "" {
+		app.wg.Add(1)
+		go application.otlpWorker(ctx)
+	}
+	return nil
+}
+
+// Stop detaches from every log and waits for the workers to exit, or for
+// ctx to be done.
+func (app *App) Stop(ctx context.Context) error {
+	app.Lock()
+	cancel := app.cancel
+	app.stopCtx = ctx
+	app.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// defaultShutdownTimeout bounds the final sends when the App is stopped by
+// its context rather than by Stop.
+const defaultShutdownTimeout = 5 * time.Second
+
+// finalContext returns the context of the final sends on shutdown, such as
+// a push or a remote write: the ctx given to Stop, or a default timeout.
+func (app *App) finalContext() (context.Context, context.CancelFunc) {
+	app.Lock()
+	stopCtx := app.stopCtx
+	app.Unlock()
+	if stopCtx == nil {
+		return context.WithTimeout(context.Background(), defaultShutdownTimeout)
 	}
-	return logFollower
+	return context.WithCancel(stopCtx)
 }
 
-func (application *Application) queueWorker(alLog *follower.Follower, maxRate int) {
+func (app *App) writeDebugMessage(debug bool, message string, applicationName string) {
+	if debug == true {
+		app.Config.Logger.Debug(strings.TrimSuffix(message, "\n"), "application", applicationName)
+	}
+}
+
+// queueWorker processes every line of the input until the input ends or
+// ctx is done. The metrics keep their final values after the input ends.
+func (application *Application) queueWorker(ctx context.Context, input Input, maxRate int) {
+	defer application.wg.Done()
+	defer input.Close()
+
+	// not sent to statsd, which would get an update for every line
 	meter := metrics.GetOrRegisterCounter("apm-log-read-rate", application.MetricsRegistry)
 	//count := 0
 	rl := ratelimit.New(maxRate) // per second
-	for line := range alLog.Lines() {
-		//use rate limiter
-		rl.Take()
+	for {
+		select {
+		case line, ok := <-input.Lines():
+			if !ok {
+				if err := input.Err(); err != nil {
+					application.logger.Error("log input failed", "log", application.LogPath, "error", err)
+				} else {
+					application.logger.Info("log input ended", "log", application.LogPath)
+				}
+				return
+			}
+			//use rate limiter
+			rl.Take()
 
-		application.CategorizeLogData(line.String(), application.ApplicationName, &application.MetricsRegistry, application.DebugEnabled)
+			application.processLine(line)
 
-		meter.Inc(1)
-		application.TotalLinesRead++
+			meter.Inc(1)
+			application.TotalLinesRead++
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
-func (application *Application) createRegistry(logPath string) metrics.Registry {
-	registry := metrics.NewRegistry()
-	return registry
+// prepare resolves the rules and line handlers of an application. The
+// caller must hold the App lock.
+func (application *Application) prepare() {
+	application.handlers = application.handlersFor(application.ApplicationName)
+	application.ruleMatches = make([]int64, len(application.rules))
 }
 
-func (application *Application) enableLogging(applicationName string, registry metrics.Registry, intervalSec time.Duration) {
-	/*         Metric Logging                                          */
-	/*  uncomment to log metrics to a file applicationName.metrics.log */
-	/*  for debugging purposes
-	filename := fmt.Sprintf("%s.metrics.log", applicationName)
-	logfile, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		log.Fatalf("error opening file: %v", err)
+// processLine counts the line's level, then runs the built-in parsing in
+// common.go, then every rule, then every line handler.
+func (application *Application) processLine(line Line) {
+	registry := application.lineRegistry()
+	fields := Fields{}
+	for name, value := range application.Labels {
+		fields[name] = value
+	}
+	for name, value := range line.Fields {
+		fields[name] = value
+	}
+	fields["application"] = application.ApplicationName
+	if level, ok := application.levelMatcher().match(line.Text, fields); ok {
+		application.levelCounts.inc(level)
+		if _, ok := fields["level"]; !ok {
+			fields["level"] = level
+		}
+	}
+
+	application.CategorizeLogData(line.Text, application.ApplicationName, &registry, application.DebugEnabled)
+	for i := range application.rules {
+		if application.rules[i].apply(application.App, line.Text, fields, registry, application.DebugEnabled) {
+			atomic.AddInt64(&application.ruleMatches[i], 1)
+		}
+	}
+
+	if len(application.handlers) == 0 {
+		return
+	}
+	timestamp := line.Time
+	if timestamp.IsZero() {
+		var ok bool
+		if timestamp, ok = parseTimestamp(line.Text); !ok {
+			timestamp = time.Now()
+		}
+	}
+	sink := registrySink{registry}
+	for _, handler := range application.handlers {
+		handler.HandleLine(line.Text, fields, timestamp, sink)
 	}
-	//defer logfile.Close()
+}
+
+func (application *Application) levelMatcher() *levelMatcher {
+	if application.levels != nil {
+		return application.levels
+	}
+	return application.App.levels
+}
+
+// lineRegistry returns the registry updated by log lines: MetricsRegistry,
+// wrapped to also send updates to statsd when that is enabled.
+func (application *Application) lineRegistry() metrics.Registry {
+	if application.statsdRegistry != nil {
+		return application.statsdRegistry
+	}
+	return application.MetricsRegistry
+}
+
+// exportedName is the application name used in metric names, which
+// discovered applications share.
+func (application *Application) exportedName() string {
+	if application.metricsName != "" {
+		return application.metricsName
+	}
+	return application.ApplicationName
+}
+
+// flushWorker copies the go-metrics registry to the prometheus collector
+// every flush interval.
+func (application *Application) flushWorker(ctx context.Context) {
+	defer application.wg.Done()
+
+	ticker := time.NewTicker(application.PrometheusConfig.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			application.flush()
+		case <-ctx.Done():
+			application.flush()
+			return
+		}
+	}
+}
+
+// flush copies the go-metrics registry to the prometheus collector now.
+func (application *Application) flush() {
+	application.flushLock.Lock()
+	defer application.flushLock.Unlock()
+	application.PrometheusConfig.UpdatePrometheusMetricsOnce()
+}
+
+// flushAll copies every application's go-metrics registry to the prometheus
+// collector, so a final textfile write or push on shutdown doesn't race the
+// flushWorkers' final flush and export stale values.
+func (app *App) flushAll() {
+	app.Lock()
+	var applications []*Application
+	for _, application := range app.Applications {
+		if application.PrometheusConfig != nil {
+			applications = append(applications, application)
+		}
+	}
+	app.Unlock()
+	for _, application := range applications {
+		application.flush()
+	}
+}
 
-	log.SetOutput(logfile)
-	go metrics.Log(registry, intervalSec, log.New(logfile, applicationName, log.Lmicroseconds))
-	*/
+func (application *Application) createRegistry(logPath string) metrics.Registry {
+	registry := metrics.NewRegistry()
+	return registry
 }
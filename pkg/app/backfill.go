@@ -0,0 +1,271 @@
+package prometheuslog
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rcrowley/go-metrics"
+)
+
+// BackfillResult summarizes a backfill.
+type BackfillResult struct {
+	Lines   int
+	Untimed int // lines without a timestamp, counted at the time of the line before
+	Samples int
+	Start   time.Time // time of the first sample
+	End     time.Time // time of the last sample
+}
+
+type backfillSample struct {
+	value float64
+	time  time.Time
+}
+
+// Backfill reads the logs at paths in order through the same parsing and
+// rules as a tailed log, and writes the value of every metric at each
+// interval of log time to w in the OpenMetrics text format, with sample
+// timestamps, for promtool tsdb create-blocks-from openmetrics. Line times
+// come from the log timestamps; a line without one, or with one earlier
+// than the line before, takes the time of the line before. Compressed logs
+// (.gz, .zst, .bz2) are decompressed. The lines are attributed to
+// applicationName and decoded in its Format.
+func (app *App) Backfill(applicationName string, paths []string, interval time.Duration, w io.Writer) (*BackfillResult, error) {
+	if interval <= 0 {
+		return nil, errors.New("backfill interval must be positive")
+	}
+	application := app.findApplication(applicationName)
+	if application == nil {
+		application = app.AddApplication(len(app.Applications), applicationName, "", app.Config.MaxIngestionRate, app.Config.Debug)
+	}
+	app.Lock()
+	application.prepare()
+	app.Unlock()
+
+	meter := metrics.GetOrRegisterCounter("apm-log-read-rate", application.MetricsRegistry)
+	result := &BackfillResult{}
+	samples := map[string][]backfillSample{}
+	counters := map[string]bool{}
+	sample := func(at time.Time) {
+		add := func(name string, value float64) {
+			samples[name] = append(samples[name], backfillSample{value, at})
+			result.Samples++
+		}
+		eachValue(application.MetricsRegistry, func(name string, value float64) {
+			series := metricName(applicationName, app.Config.Environment, name)
+			switch application.MetricsRegistry.Get(name).(type) {
+			case metrics.Counter, metrics.Meter:
+				counters[series] = true
+			}
+			add(series, value)
+		})
+		application.exposedLevelValues(applicationName, func(name string, value float64) {
+			counters[name] = true
+			add(name, value)
+		})
+		if result.Start.IsZero() {
+			result.Start = at
+		}
+		result.End = at
+	}
+
+	// next is the end of the current interval; lines before the first
+	// timestamp belong to the first interval
+	var last, next time.Time
+	for _, path := range paths {
+		var input Input = newReaderInput(func() (io.ReadCloser, error) { return openLog(path) }, false)
+		if application.Format != "" {
+			input = newDecoderInput(input, application.Format)
+		}
+		for line := range input.Lines() {
+			timestamp := line.Time
+			if timestamp.IsZero() {
+				timestamp, _ = parseTimestamp(line.Text)
+			}
+			switch {
+			case timestamp.IsZero():
+				result.Untimed++
+				timestamp = last
+			case timestamp.Before(last):
+				timestamp = last
+			case next.IsZero():
+				next = timestamp.Truncate(interval).Add(interval)
+			}
+			for !next.IsZero() && !timestamp.Before(next) {
+				sample(next)
+				next = next.Add(interval)
+			}
+			last = timestamp
+
+			line.Time = timestamp
+			application.processLine(line)
+			meter.Inc(1)
+			application.TotalLinesRead++
+			result.Lines++
+		}
+		err := input.Err()
+		input.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if next.IsZero() {
+		return nil, errors.New("no line has a timestamp")
+	}
+	sample(next)
+
+	if err := writeOpenMetrics(w, samples, counters); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// writeOpenMetrics writes the samples of each series together, and the
+// series of each metric family together. A family is a counter when its
+// series are counters named *_total, since OpenMetrics requires the suffix
+// on counter samples, and a gauge otherwise, without the suffix, which
+// OpenMetrics reserves for counters. Series names may include labels.
+func writeOpenMetrics(w io.Writer, samples map[string][]backfillSample, counters map[string]bool) error {
+	family := func(name string) string {
+		family, _, _ := strings.Cut(name, "{")
+		return family
+	}
+	// by the name written
+	series := make(map[string]string, len(samples))
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		written := name
+		if gauge, ok := strings.CutSuffix(family(name), "_total"); ok && !counters[name] {
+			written = gauge + name[len(family(name)):]
+		}
+		series[written] = name
+		names = append(names, written)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if family(names[i]) != family(names[j]) {
+			return family(names[i]) < family(names[j])
+		}
+		return names[i] < names[j]
+	})
+
+	buffered := bufio.NewWriter(w)
+	for i, name := range names {
+		if i == 0 || family(name) != family(names[i-1]) {
+			if counter, ok := strings.CutSuffix(family(name), "_total"); ok && counters[series[name]] {
+				fmt.Fprintf(buffered, "# TYPE %s counter\n", counter)
+			} else {
+				fmt.Fprintf(buffered, "# TYPE %s gauge\n", family(name))
+			}
+		}
+		for _, sample := range samples[series[name]] {
+			fmt.Fprintf(buffered, "%s %s %s\n", name,
+				strconv.FormatFloat(sample.value, 'f', -1, 64),
+				strconv.FormatFloat(float64(sample.time.UnixMilli())/1000, 'f', -1, 64))
+		}
+	}
+	buffered.WriteString("# EOF\n")
+	return buffered.Flush()
+}
+
+// RotatedLogs returns logPath after its rotated copies, oldest first: dated
+// copies (app.log-20260101, app.log.20260101-000000.gz) in name order, then
+// numbered copies from the highest number (app.log.2.gz, app.log.1). logPath
+// itself is left out when it doesn't exist.
+func RotatedLogs(logPath string) ([]string, error) {
+	directory, base := filepath.Split(logPath)
+	entries, err := os.ReadDir(filepath.Clean(directory + "."))
+	if err != nil {
+		return nil, err
+	}
+	type numbered struct {
+		path string
+		n    int
+	}
+	var dated []string
+	var numberedLogs []numbered
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || len(name) <= len(base)+1 || !strings.HasPrefix(name, base) {
+			continue
+		}
+		separator, suffix := name[len(base)], trimCompression(name[len(base)+1:])
+		if separator != '.' && separator != '-' {
+			continue
+		}
+		if n, err := strconv.Atoi(suffix); err == nil && separator == '.' {
+			numberedLogs = append(numberedLogs, numbered{filepath.Join(directory, name), n})
+		} else if suffix != "" && suffix[0] >= '0' && suffix[0] <= '9' {
+			dated = append(dated, filepath.Join(directory, name))
+		}
+	}
+	sort.Strings(dated)
+	sort.Slice(numberedLogs, func(i, j int) bool { return numberedLogs[i].n > numberedLogs[j].n })
+
+	paths := dated
+	for _, log := range numberedLogs {
+		paths = append(paths, log.path)
+	}
+	if _, err := os.Stat(logPath); err == nil {
+		paths = append(paths, logPath)
+	}
+	return paths, nil
+}
+
+func trimCompression(name string) string {
+	for _, extension := range []string{".gz", ".zst", ".bz2"} {
+		if trimmed, ok := strings.CutSuffix(name, extension); ok {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// openLog opens a log, decompressing it according to its extension.
+func openLog(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var reader io.Reader
+	switch filepath.Ext(path) {
+	case ".gz":
+		reader, err = gzip.NewReader(file)
+	case ".zst":
+		var decoder *zstd.Decoder
+		if decoder, err = zstd.NewReader(file); err == nil {
+			reader = decoder.IOReadCloser()
+		}
+	case ".bz2":
+		reader = bzip2.NewReader(file)
+	default:
+		return file, nil
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return decompressedLog{reader, file}, nil
+}
+
+// decompressedLog closes the decompressor, if it can be, and the file.
+type decompressedLog struct {
+	io.Reader
+	file *os.File
+}
+
+func (log decompressedLog) Close() error {
+	if closer, ok := log.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+	return log.file.Close()
+}
@@ -0,0 +1,181 @@
+package prometheuslog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+)
+
+// openMetricsSample is a sample read back by parseOpenMetrics.
+type openMetricsSample struct {
+	value     float64
+	timestamp int64 // milliseconds
+}
+
+// parseOpenMetrics parses data with the OpenMetrics parser promtool uses,
+// checking the sample names against their family type, and returns the
+// samples and type of every series.
+func parseOpenMetrics(t *testing.T, data []byte) (map[string][]openMetricsSample, map[string]model.MetricType) {
+	t.Helper()
+	samples := map[string][]openMetricsSample{}
+	types := map[string]model.MetricType{}
+	parser := textparse.NewOpenMetricsParser(data, labels.NewSymbolTable())
+	var family string
+	var familyType model.MetricType
+	for {
+		entry, err := parser.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%v in:\n%s", err, data)
+		}
+		switch entry {
+		case textparse.EntryType:
+			name, metricType := parser.Type()
+			family, familyType = string(name), metricType
+		case textparse.EntrySeries:
+			series, timestamp, value := parser.Series()
+			var lset labels.Labels
+			parser.Labels(&lset)
+			name := lset.Get(labels.MetricName)
+			switch {
+			case familyType == model.MetricTypeCounter && name != family+"_total":
+				t.Errorf("counter sample %s in the family %s", name, family)
+			case familyType == model.MetricTypeGauge && (name != family || strings.HasSuffix(name, "_total")):
+				t.Errorf("gauge sample %s in the family %s", name, family)
+			}
+			if timestamp == nil {
+				t.Fatalf("%s has no timestamp", series)
+			}
+			samples[string(series)] = append(samples[string(series)], openMetricsSample{value, *timestamp})
+			types[string(series)] = familyType
+		}
+	}
+	return samples, types
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	first := time.Unix(1772272800, 0)
+	second := first.Add(time.Minute + 500*time.Millisecond)
+	samples := map[string][]backfillSample{
+		"myapp_prod_errors_total":                      {{1, first}, {3, second}},
+		"myapp_prod_hits":                              {{7, first}, {8, second}},
+		"myapp_prod_latency":                           {{0.25, first}, {0.125, second}},
+		`myapp_prod_log_messages_total{level="error"}`: {{1, first}, {2, second}},
+		`myapp_prod_log_messages_total{level="info"}`:  {{5, first}, {5, second}},
+		"myapp_prod_queued_total":                      {{4, first}, {0.5, second}},
+	}
+	// hits is a counter without the suffix and queued_total a gauge with it
+	counters := map[string]bool{
+		"myapp_prod_errors_total":                      true,
+		"myapp_prod_hits":                              true,
+		`myapp_prod_log_messages_total{level="error"}`: true,
+		`myapp_prod_log_messages_total{level="info"}`:  true,
+	}
+	golden := `# TYPE myapp_prod_errors counter
+myapp_prod_errors_total 1 1772272800
+myapp_prod_errors_total 3 1772272860.5
+# TYPE myapp_prod_hits gauge
+myapp_prod_hits 7 1772272800
+myapp_prod_hits 8 1772272860.5
+# TYPE myapp_prod_latency gauge
+myapp_prod_latency 0.25 1772272800
+myapp_prod_latency 0.125 1772272860.5
+# TYPE myapp_prod_log_messages counter
+myapp_prod_log_messages_total{level="error"} 1 1772272800
+myapp_prod_log_messages_total{level="error"} 2 1772272860.5
+myapp_prod_log_messages_total{level="info"} 5 1772272800
+myapp_prod_log_messages_total{level="info"} 5 1772272860.5
+# TYPE myapp_prod_queued gauge
+myapp_prod_queued 4 1772272800
+myapp_prod_queued 0.5 1772272860.5
+# EOF
+`
+	var b bytes.Buffer
+	if err := writeOpenMetrics(&b, samples, counters); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != golden {
+		t.Errorf("wrote:\n%s\nwant:\n%s", b.String(), golden)
+	}
+
+	parsed, types := parseOpenMetrics(t, b.Bytes())
+	for name, want := range samples {
+		written := name
+		if name == "myapp_prod_queued_total" {
+			written = "myapp_prod_queued"
+		}
+		wantType := model.MetricTypeGauge
+		if counters[name] && strings.Contains(name, "_total") {
+			wantType = model.MetricTypeCounter
+		}
+		if types[written] != wantType {
+			t.Errorf("%s is a %s, want a %s", written, types[written], wantType)
+		}
+		if len(parsed[written]) != len(want) {
+			t.Errorf("%s has %d samples, want %d", written, len(parsed[written]), len(want))
+			continue
+		}
+		for i, sample := range want {
+			if got := parsed[written][i]; got.value != sample.value || got.timestamp != sample.time.UnixMilli() {
+				t.Errorf("%s sample %d is %v, want %g at %d", written, i, got, sample.value, sample.time.UnixMilli())
+			}
+		}
+	}
+}
+
+func TestBackfillOpenMetrics(t *testing.T) {
+	app := NewApp()
+	log := strings.Join([]string{
+		"2026-02-28T10:00:05Z ERROR payment failed",
+		"2026-02-28T10:00:30Z INFO payment accepted",
+		"ERROR retry failed without a timestamp",
+		"2026-02-28T10:01:10Z WARN slow payment",
+	}, "\n") + "\n"
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	result, err := app.Backfill("myapp", []string{path}, time.Minute, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Lines != 4 || result.Untimed != 1 {
+		t.Errorf("%d lines with %d untimed, want 4 with 1", result.Lines, result.Untimed)
+	}
+
+	parsed, types := parseOpenMetrics(t, b.Bytes())
+	first, second := time.Date(2026, 2, 28, 10, 1, 0, 0, time.UTC).UnixMilli(), time.Date(2026, 2, 28, 10, 2, 0, 0, time.UTC).UnixMilli()
+	want := map[string][]openMetricsSample{
+		// the line without a timestamp takes the time of the line before
+		`myapp_prod_log_messages_total{level="error"}`: {{2, first}, {2, second}},
+		`myapp_prod_log_messages_total{level="info"}`:  {{1, first}, {1, second}},
+		`myapp_prod_log_messages_total{level="warn"}`:  {{1, second}},
+	}
+	for name, samples := range want {
+		if types[name] != model.MetricTypeCounter {
+			t.Errorf("%s is a %s, want a counter", name, types[name])
+		}
+		if len(parsed[name]) != len(samples) {
+			t.Errorf("%s has %v, want %v", name, parsed[name], samples)
+			continue
+		}
+		for i := range samples {
+			if parsed[name][i] != samples[i] {
+				t.Errorf("%s has %v, want %v", name, parsed[name], samples)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package prometheuslog
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Check validates config without starting anything: it finds every
+// problem New would reject, every rule and script is compiled, application
+// names must be unique, every log must be readable, and a metric name may
+// only be used as one type. It returns every problem found.
+func (config *Config) Check() []error {
+	defaulted := *config
+	defaulted.setDefaults()
+	problems := defaulted.validate()
+	if config.Kubernetes.LogDirectory != "" {
+		if _, err := os.ReadDir(config.Kubernetes.LogDirectory); err != nil {
+			problems = append(problems, fmt.Errorf("kubernetes: pod log directory is not readable: %v", err))
+		}
+	}
+
+	applications := map[string]bool{}
+	stdin := ""
+	for i, application := range config.Applications {
+		if application.Name == "" {
+			problems = append(problems, fmt.Errorf("application %d: name is empty", i+1))
+		} else if applications[application.Name] {
+			problems = append(problems, fmt.Errorf("application %q: duplicate name", application.Name))
+		}
+		applications[application.Name] = true
+
+		if application.LogPath == StdinPath {
+			if stdin != "" {
+				problems = append(problems, fmt.Errorf("application %q: standard input is already read by %q", application.Name, stdin))
+			}
+			stdin = application.Name
+			continue
+		}
+		if application.LogPath == IngestPath {
+			if len(config.IngestTokens) == 0 {
+				problems = append(problems, fmt.Errorf("application %q: no ingest token is configured", application.Name))
+			}
+			continue
+		}
+		if strings.HasPrefix(application.LogPath, SyslogPathPrefix) {
+			if _, err := parseSyslogRoute(application.LogPath); err != nil {
+				problems = append(problems, fmt.Errorf("application %q: %v", application.Name, err))
+			}
+			if len(config.Syslog.Listen) == 0 {
+				problems = append(problems, fmt.Errorf("application %q: no syslog listener is configured", application.Name))
+			}
+			continue
+		}
+		if isNamedPipe(application.LogPath) {
+			// opening a named pipe waits for a writer
+			continue
+		}
+		file, err := os.Open(application.LogPath)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("application %q: log is not readable: %v", application.Name, err))
+			continue
+		}
+		file.Close()
+	}
+
+	type metricUse struct {
+		metricType string
+		source     string
+	}
+	metricUses := map[string]metricUse{}
+	for name, metricType := range builtinMetricTypes {
+		metricUses[flattenKey(name)] = metricUse{metricType, "common.go"}
+	}
+
+	rules := map[string]bool{}
+	for i := range config.Rules {
+		rule := config.Rules[i]
+		if rule.Name == "" {
+			problems = append(problems, fmt.Errorf("rule %d: name is empty", i+1))
+		} else if rules[rule.Name] {
+			problems = append(problems, fmt.Errorf("rule %q: duplicate name", rule.Name))
+		}
+		rules[rule.Name] = true
+
+		if err := rule.compile(); err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		if rule.Application != "" && len(config.Applications) > 0 && !applications[rule.Application] {
+			problems = append(problems, fmt.Errorf("rule %q: unknown application %q", rule.Name, rule.Application))
+		}
+		if rule.Metric == "" || rule.script != nil {
+			continue
+		}
+
+		source := fmt.Sprintf("rule %q", rule.Name)
+		key := flattenKey(rule.Metric)
+		if existing, ok := metricUses[key]; !ok {
+			metricUses[key] = metricUse{rule.Type, source}
+		} else if existing.metricType != rule.Type {
+			problems = append(problems, fmt.Errorf("%s: metric %q is a %s, but a %s in %s", source, key, rule.Type, existing.metricType, existing.source))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,34 @@
+package prometheuslog
+
+import "testing"
+
+func TestCheckMatchesNew(t *testing.T) {
+	for name, config := range map[string]Config{
+		"snapshot": {Snapshot: SnapshotConfig{Directory: t.TempDir(), Format: "xml"}},
+		"otlp":     {OTLP: OTLPConfig{Endpoint: "localhost:4317", Protocol: "udp"}},
+		"statsd":   {StatsD: StatsDConfig{Address: "localhost:8125", Flavor: "graphite"}},
+		"watch":    {Watch: "sometimes"},
+		"format":   {Applications: []ApplicationConfig{{Name: "myapp", LogPath: StdinPath, Format: "xml"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(config)
+			if err == nil {
+				t.Fatal("New accepted the config")
+			}
+			problems := config.Check()
+			found := false
+			for _, problem := range problems {
+				found = found || problem.Error() == err.Error()
+			}
+			if !found {
+				t.Errorf("Check found %v, not New's %q", problems, err)
+			}
+		})
+	}
+
+	// the defaults New sets are valid
+	config := Config{Snapshot: SnapshotConfig{Directory: t.TempDir()}, OTLP: OTLPConfig{Endpoint: "localhost:4317"}}
+	if problems := config.Check(); len(problems) > 0 {
+		t.Errorf("problems with the defaults: %v", problems)
+	}
+}
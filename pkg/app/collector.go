@@ -0,0 +1,159 @@
+package prometheuslog
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rcrowley/go-metrics"
+)
+
+// collector is handed to each application's go-metrics prometheus provider
+// as its prometheus.Registerer, and collects every gauge the providers
+// register. It describes nothing, which makes it an unchecked collector: the
+// set of metrics grows as new log lines are matched.
+type collector struct {
+	sync.Mutex
+	collectors []prometheus.Collector
+}
+
+func (c *collector) Register(pc prometheus.Collector) error {
+	c.Lock()
+	defer c.Unlock()
+	c.collectors = append(c.collectors, pc)
+	return nil
+}
+
+func (c *collector) MustRegister(pcs ...prometheus.Collector) {
+	for _, pc := range pcs {
+		c.Register(pc)
+	}
+}
+
+func (c *collector) Unregister(pc prometheus.Collector) bool {
+	c.Lock()
+	defer c.Unlock()
+	for i, existing := range c.collectors {
+		if existing == pc {
+			c.collectors = append(c.collectors[:i], c.collectors[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// registered returns how many collectors are registered, for unregisterFrom.
+func (c *collector) registered() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.collectors)
+}
+
+// unregisterFrom unregisters every collector registered after the first n.
+func (c *collector) unregisterFrom(n int) {
+	c.Lock()
+	defer c.Unlock()
+	if n < len(c.collectors) {
+		c.collectors = c.collectors[:n]
+	}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	collectors := make([]prometheus.Collector, len(c.collectors))
+	copy(collectors, c.collectors)
+	c.Unlock()
+
+	for _, pc := range collectors {
+		pc.Collect(ch)
+	}
+}
+
+// registryCollector exports the counters, gauges and meters of a go-metrics
+// registry with constant labels, which the go-metrics prometheus provider
+// can't add. Metrics are read from the registry when collected, so there is
+// nothing to flush.
+type registryCollector struct {
+	registry    metrics.Registry
+	name        string
+	environment string
+	labelNames  []string
+	labelValues []string
+}
+
+func newRegistryCollector(registry metrics.Registry, name string, environment string, labels map[string]string) *registryCollector {
+	c := &registryCollector{registry: registry, name: name, environment: environment}
+	c.labelNames, c.labelValues = sortedLabels(labels)
+	return c
+}
+
+// sortedLabels returns the names of labels, sorted, and their values.
+func sortedLabels(labels map[string]string) (names []string, values []string) {
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values = append(values, labels[name])
+	}
+	return names, values
+}
+
+func (c *registryCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *registryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.registry.Each(func(name string, i interface{}) {
+		valueType, value := prometheus.GaugeValue, 0.0
+		switch metric := i.(type) {
+		case metrics.Counter:
+			valueType, value = prometheus.CounterValue, float64(metric.Count())
+		case metrics.Meter:
+			valueType, value = prometheus.CounterValue, float64(metric.Count())
+		case metrics.Gauge:
+			value = float64(metric.Value())
+		case metrics.GaugeFloat64:
+			value = metric.Value()
+		default:
+			return
+		}
+		desc := prometheus.NewDesc(metricName(c.name, c.environment, name), name, c.labelNames, nil)
+		ch <- prometheus.MustNewConstMetric(desc, valueType, value, c.labelValues...)
+	})
+}
+
+// Collector returns a prometheus.Collector with the metrics of every
+// application, to register with an existing prometheus registry.
+func (app *App) Collector() prometheus.Collector {
+	return app.collector
+}
+
+// Handler returns an http.Handler serving the metrics of every application
+// in the prometheus text format, to mount in an existing server.
+func (app *App) Handler() http.Handler {
+	return promhttp.HandlerFor(app.gatherer(), promhttp.HandlerOpts{})
+}
+
+// gatherer returns a registry holding only the metrics of the App.
+func (app *App) gatherer() prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(app.collector)
+	return registry
+}
+
+// metricName returns the name a go-metrics metric is exposed under by the
+// prometheus provider: <application>_<environment>_<metric>, with dashes,
+// dots, spaces and equals signs converted to underscores.
+func metricName(applicationName string, environment string, name string) string {
+	return prometheus.BuildFQName(flattenKey(applicationName), flattenKey(environment), flattenKey(name))
+}
+
+var keyReplacer = strings.NewReplacer(" ", "_", ".", "_", "-", "_", "=", "_")
+
+func flattenKey(key string) string {
+	return keyReplacer.Replace(key)
+}
@@ -38,6 +38,24 @@ type Data struct {
 	Datasource *Datasource `json:"dataSource"`
 }
 
+// builtinMetricTypes lists the metrics updated by the functions in this file
+// and by the application worker, so check-config can detect rules that reuse
+// one of these names with a different type. Keep it in sync when adding
+// metrics here.
+var builtinMetricTypes = map[string]string{
+	"apm-log-read-rate":                     RuleTypeCounter,
+	"apm-alert-created-total":               RuleTypeCounter,
+	"apm-webharvest-exit-duration":          RuleTypeGauge,
+	"apm-common-memoryfree-bytes":           RuleTypeGauge,
+	"apm-common-memorytotal-bytes":          RuleTypeGauge,
+	"apm-metric-alertsrunning-total":        RuleTypeGauge,
+	"apm-metric-alertcreationrate-total":    RuleTypeGauge,
+	"apm-metric-payloadreceptionrate-total": RuleTypeGauge,
+	"apm-metric-casecreationrate-total":     RuleTypeGauge,
+	"apm-metric-caseterminaterate-total":    RuleTypeGauge,
+	levelMetric:                             RuleTypeCounter,
+}
+
 func (dashBoard *App) CategorizeLogData(line string, applicationName string, registry *metrics.Registry, debug bool) {
 	/* This section is responsible for processing the logs. */
 	/* Logs are read in line by line, the functions below   */
@@ -58,31 +76,6 @@ func (dashBoard *App) CategorizeLogData(line string, applicationName string, reg
 		dashBoard.parseMetricMessages(line, applicationName, *registry, debug)
 	}
 
-	//WARNs
-	if strings.Contains(line, "WARN") {
-		debugline := fmt.Sprintf("%s\n", line)
-		dashBoard.writeDebugMessage(debug, debugline, applicationName)
-
-		counter := metrics.GetOrRegisterCounter("common-warn-messages-total", *registry)
-		counter.Inc(1)
-	}
-	//ERRORs
-	if strings.Contains(line, "ERROR") {
-		debugline := fmt.Sprintf("%s\n", line)
-		dashBoard.writeDebugMessage(debug, debugline, applicationName)
-
-		counter := metrics.GetOrRegisterCounter("common-error-messages-total", *registry)
-		counter.Inc(1)
-	}
-	//FATALs
-	if strings.Contains(line, "FATAL") {
-		debugline := fmt.Sprintf("%s\n", line)
-		dashBoard.writeDebugMessage(debug, debugline, applicationName)
-		counter := metrics.GetOrRegisterCounter("apm-common-fatal-messages-total", *registry)
-		counter.Inc(1)
-
-	}
-
 }
 func (dashBoard *App) parseCommonMessages(line string, applicationName string, registry metrics.Registry, debug bool) {
 
@@ -155,7 +148,7 @@ func (dashBoard *App) parseMetricMessages(line string, applicationName string, r
 	*/
 
 	//rescue is needed in case JSON parsing goes south
-	defer rescue()
+	defer dashBoard.rescue(applicationName)
 
 	if debug == true {
 		debugline := fmt.Sprintf("Common - Processing Metrics")
@@ -222,13 +215,12 @@ func (dashBoard *App) parseMetricMessages(line string, applicationName string, r
 
 }
 
-func rescue() {
+func (dashBoard *App) rescue(applicationName string) {
 	// Check the execution state
 	r := recover()
 	// nil means we are in a normal execution
 	if r != nil {
 		// here, a panic has been occurred somewhere
-		fmt.Printf("Panic (code %d) has been recovered from somewhere\n", r)
-		// do something here to handle the panic
+		dashBoard.Config.Logger.Error("recovered from panic while parsing", "application", applicationName, "panic", r)
 	}
 }
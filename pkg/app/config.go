@@ -0,0 +1,219 @@
+package prometheuslog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds everything needed to construct an App with New.
+type Config struct {
+	Environment      string        // staging, uat or prod; used as the metric subsystem
+	FlushInterval    time.Duration // how often metrics are copied to the prometheus collector
+	MaxIngestionRate int           // log lines read per second, per application
+	Debug            bool
+	Logger           *slog.Logger // defaults to slog.Default()
+	Snapshot         SnapshotConfig
+	Textfile         string // when set, metrics are also written to this .prom file every flush interval
+	Push             PushConfig
+	RemoteWrite      RemoteWriteConfig
+	OTLP             OTLPConfig
+	StatsD           StatsDConfig
+	Graphite         GraphiteConfig
+	InfluxDB         InfluxDBConfig
+	Syslog           SyslogConfig
+	Kubernetes       KubernetesConfig
+	Watch            string        // how log files are watched: WatchAuto (default), WatchInotify or WatchPoll
+	PollInterval     time.Duration // how often polled log files are checked, default 250ms
+	Level            LevelConfig   // how the level of each line is found
+	IngestTokens     []string      // bearer tokens accepted by IngestHandler
+	Applications     []ApplicationConfig
+	Rules            []Rule
+}
+
+// ApplicationConfig is a single application (one line of prometheuslog.conf).
+type ApplicationConfig struct {
+	Name         string
+	LogPath      string        // a file, a named pipe, StdinPath, IngestPath, or a SyslogPathPrefix selector
+	Format       string        // FormatDocker or FormatCRI to unwrap container log lines; plain text when empty
+	Watch        string        // overrides Config.Watch when set
+	PollInterval time.Duration // overrides Config.PollInterval when set
+	Level        LevelConfig   // Field or Regex override Config.Level's; Aliases are added to it
+}
+
+const (
+	defaultEnvironment      = "prod"
+	defaultFlushInterval    = 2 * time.Second
+	defaultMaxIngestionRate = 10000
+)
+
+func (config *Config) setDefaults() {
+	if config.Environment == "" {
+		config.Environment = defaultEnvironment
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+	if config.MaxIngestionRate <= 0 {
+		config.MaxIngestionRate = defaultMaxIngestionRate
+	}
+	if config.Watch == "" {
+		config.Watch = WatchAuto
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultPollInterval
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	if config.Snapshot.Directory != "" {
+		config.Snapshot.setDefaults()
+	}
+	if config.Push.URL != "" {
+		config.Push.setDefaults(config.FlushInterval)
+	}
+	if config.RemoteWrite.URL != "" {
+		config.RemoteWrite.setDefaults(config.FlushInterval)
+	}
+	if config.OTLP.Endpoint != "" {
+		config.OTLP.setDefaults(config.FlushInterval)
+	}
+	if config.StatsD.Address != "" {
+		config.StatsD.setDefaults()
+	}
+	if config.Graphite.Address != "" {
+		config.Graphite.setDefaults(config.FlushInterval)
+	}
+	if config.InfluxDB.URL != "" {
+		config.InfluxDB.setDefaults(config.FlushInterval)
+	}
+	if config.Kubernetes.LogDirectory != "" {
+		config.Kubernetes.setDefaults()
+	}
+}
+
+// validate returns the problems of a config, with its defaults set, that
+// New rejects. Check reports them too, so the two can't disagree.
+func (config *Config) validate() []error {
+	var problems []error
+	if config.Snapshot.Directory != "" {
+		if err := config.Snapshot.check(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if config.OTLP.Endpoint != "" {
+		if err := config.OTLP.check(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if config.StatsD.Address != "" {
+		if err := config.StatsD.check(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if err := config.Syslog.check(); err != nil {
+		problems = append(problems, err)
+	}
+	if err := checkWatch(config.Watch); err != nil {
+		problems = append(problems, err)
+	}
+	if config.Kubernetes.LogDirectory != "" {
+		if err := config.Kubernetes.check(); err != nil {
+			problems = append(problems, fmt.Errorf("kubernetes: %v", err))
+		}
+	}
+	if _, err := config.Level.compile(); err != nil {
+		problems = append(problems, err)
+	}
+	for _, application := range config.Applications {
+		if err := checkFormat(application.Format); err != nil {
+			problems = append(problems, fmt.Errorf("application %q: %v", application.Name, err))
+		}
+		if err := checkWatch(application.Watch); err != nil {
+			problems = append(problems, fmt.Errorf("application %q: %v", application.Name, err))
+		}
+		if _, err := config.Level.levelFor(application.Level).compile(); err != nil {
+			problems = append(problems, fmt.Errorf("application %q: %v", application.Name, err))
+		}
+	}
+	return problems
+}
+
+// ReadConfigFile parses a prometheuslog.conf file, one application per line,
+// optionally followed by name=value options:
+//
+//	myFirstApplication,/Users/myuser/filename-1.log
+//	myPod,/var/log/pods/default_mypod_1234/app/0.log,format=cri
+//	myNFSApplication,/mnt/nfs/app.log,watch=poll,poll-interval=2s
+//	myJSONApplication,/var/log/app.json,"level-regex=""level"":""(\w+)"""
+func ReadConfigFile(fileName string) ([]ApplicationConfig, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the conf file %s: %v", fileName, err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	lines, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the conf file %s: %v", fileName, err)
+	}
+
+	applications := make([]ApplicationConfig, 0, len(lines))
+	for i, line := range lines {
+		if len(line) < 2 {
+			return nil, fmt.Errorf("%s line %d: expected <application name>,<log path>", fileName, i+1)
+		}
+		application := ApplicationConfig{
+			Name:    line[0],
+			LogPath: line[1],
+		}
+		for _, option := range line[2:] {
+			name, value, _ := strings.Cut(option, "=")
+			switch name {
+			case "format":
+				application.Format = value
+			case "watch":
+				application.Watch = value
+			case "poll-interval":
+				interval, err := time.ParseDuration(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s line %d: invalid poll-interval %q: %v", fileName, i+1, value, err)
+				}
+				application.PollInterval = interval
+			case "level-field":
+				field, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s line %d: invalid level-field %q: %v", fileName, i+1, value, err)
+				}
+				application.Level.Field = field
+			case "level-regex":
+				application.Level.Regex = value
+			default:
+				return nil, fmt.Errorf("%s line %d: unknown option %q", fileName, i+1, option)
+			}
+		}
+		applications = append(applications, application)
+	}
+	return applications, nil
+}
+
+// ReadRulesFile parses a JSON array of declarative rules.
+func ReadRulesFile(fileName string) ([]Rule, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the rules file %s: %v", fileName, err)
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse the rules file %s: %v", fileName, err)
+	}
+	return rules, nil
+}
@@ -0,0 +1,166 @@
+package prometheuslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats an application's lines can be wrapped in. Decoded lines have
+// the fields stream (stdout or stderr) and time, the container runtime's
+// timestamp, which is also the line's timestamp for line handlers.
+const (
+	FormatDocker = "docker" // Docker json-file: {"log":"...\n","stream":"stdout","time":"..."}
+	FormatCRI    = "cri"    // CRI, as in /var/log/pods: <time> <stream> <P|F> <message>
+)
+
+func checkFormat(format string) error {
+	switch format {
+	case "", FormatDocker, FormatCRI:
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q, expected %s or %s", format, FormatDocker, FormatCRI)
+}
+
+// containerLine is one line of a container log file. Partial lines are
+// pieces of a long line split by the runtime; the piece that completes it
+// is not partial.
+type containerLine struct {
+	message string
+	stream  string
+	time    string
+	partial bool
+}
+
+func decodeDocker(text string) (containerLine, bool) {
+	var entry struct {
+		Log    string `json:"log"`
+		Stream string `json:"stream"`
+		Time   string `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(text), &entry); err != nil {
+		return containerLine{}, false
+	}
+	message, complete := strings.CutSuffix(entry.Log, "\n")
+	return containerLine{message: message, stream: entry.Stream, time: entry.Time, partial: !complete}, true
+}
+
+func decodeCRI(text string) (containerLine, bool) {
+	parts := strings.SplitN(text, " ", 4)
+	if len(parts) < 3 {
+		return containerLine{}, false
+	}
+	if _, err := time.Parse(time.RFC3339Nano, parts[0]); err != nil {
+		return containerLine{}, false
+	}
+	line := containerLine{stream: parts[1], time: parts[0], partial: strings.HasPrefix(parts[2], "P")}
+	if len(parts) == 4 {
+		line.message = parts[3]
+	}
+	return line, true
+}
+
+// decoderInput unwraps the lines of another Input and joins partial lines,
+// separately for each stream. Lines that aren't in the format are passed
+// through unchanged.
+type decoderInput struct {
+	input Input
+	lines chan Line
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newDecoderInput(input Input, format string) *decoderInput {
+	decode := decodeDocker
+	if format == FormatCRI {
+		decode = decodeCRI
+	}
+	decoder := &decoderInput{
+		input: input,
+		lines: make(chan Line),
+		done:  make(chan struct{}),
+	}
+	go decoder.decode(decode)
+	return decoder
+}
+
+func (decoder *decoderInput) decode(decode func(string) (containerLine, bool)) {
+	defer close(decoder.lines)
+
+	type pending struct {
+		text  strings.Builder
+		first containerLine
+	}
+	partials := map[string]*pending{}
+	send := func(line Line) bool {
+		select {
+		case decoder.lines <- line:
+			return true
+		case <-decoder.done:
+			return false
+		}
+	}
+	complete := func(first containerLine, text string) Line {
+		line := Line{Text: text, Fields: Fields{"stream": first.stream, "time": first.time}}
+		line.Time, _ = time.Parse(time.RFC3339Nano, first.time)
+		return line
+	}
+
+	for {
+		var line Line
+		var ok bool
+		select {
+		case line, ok = <-decoder.input.Lines():
+		case <-decoder.done:
+			return
+		}
+		if !ok {
+			// the input ended in the middle of a line
+			for _, p := range partials {
+				if !send(complete(p.first, p.text.String())) {
+					return
+				}
+			}
+			return
+		}
+
+		decoded, ok := decode(line.Text)
+		if !ok {
+			if !send(line) {
+				return
+			}
+			continue
+		}
+		p := partials[decoded.stream]
+		if p == nil && !decoded.partial {
+			if !send(complete(decoded, decoded.message)) {
+				return
+			}
+			continue
+		}
+		if p == nil {
+			p = &pending{first: decoded}
+			partials[decoded.stream] = p
+		}
+		p.text.WriteString(decoded.message)
+		if decoded.partial && p.text.Len() < maxLineLength {
+			continue
+		}
+		delete(partials, decoded.stream)
+		if !send(complete(p.first, p.text.String())) {
+			return
+		}
+	}
+}
+
+func (decoder *decoderInput) Lines() <-chan Line { return decoder.lines }
+func (decoder *decoderInput) Err() error         { return decoder.input.Err() }
+
+func (decoder *decoderInput) Close() {
+	decoder.once.Do(func() {
+		close(decoder.done)
+		decoder.input.Close()
+	})
+}
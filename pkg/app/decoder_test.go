@@ -0,0 +1,179 @@
+package prometheuslog
+
+import (
+	"testing"
+	"time"
+)
+
+// sliceInput is an Input of the given lines, which ends after them.
+type sliceInput struct {
+	lines chan Line
+}
+
+func newSliceInput(texts ...string) *sliceInput {
+	input := &sliceInput{lines: make(chan Line, len(texts))}
+	for _, text := range texts {
+		input.lines <- Line{Text: text}
+	}
+	close(input.lines)
+	return input
+}
+
+func (input *sliceInput) Lines() <-chan Line { return input.lines }
+func (input *sliceInput) Err() error         { return nil }
+func (input *sliceInput) Close()             {}
+
+func TestDecoderInput(t *testing.T) {
+	type decoded struct {
+		text   string
+		stream string
+		time   string
+	}
+	tests := []struct {
+		name   string
+		format string
+		lines  []string
+		want   []decoded
+	}{
+		{
+			name:   "docker",
+			format: FormatDocker,
+			lines: []string{
+				`{"log":"first\n","stream":"stdout","time":"2026-02-28T10:00:00.000000001Z"}`,
+				`{"log":"second\n","stream":"stderr","time":"2026-02-28T10:00:01Z"}`,
+			},
+			want: []decoded{
+				{"first", "stdout", "2026-02-28T10:00:00.000000001Z"},
+				{"second", "stderr", "2026-02-28T10:00:01Z"},
+			},
+		},
+		{
+			// a record without a trailing newline continues in the next
+			// of its stream, and the line has the first record's time
+			name:   "docker split line",
+			format: FormatDocker,
+			lines: []string{
+				`{"log":"a long ","stream":"stdout","time":"2026-02-28T10:00:00Z"}`,
+				`{"log":"other\n","stream":"stderr","time":"2026-02-28T10:00:01Z"}`,
+				`{"log":"line that was ","stream":"stdout","time":"2026-02-28T10:00:02Z"}`,
+				`{"log":"split\n","stream":"stdout","time":"2026-02-28T10:00:03Z"}`,
+			},
+			want: []decoded{
+				{"other", "stderr", "2026-02-28T10:00:01Z"},
+				{"a long line that was split", "stdout", "2026-02-28T10:00:00Z"},
+			},
+		},
+		{
+			name:   "docker partial line at the end",
+			format: FormatDocker,
+			lines: []string{
+				`{"log":"complete\n","stream":"stdout","time":"2026-02-28T10:00:00Z"}`,
+				`{"log":"never ","stream":"stdout","time":"2026-02-28T10:00:01Z"}`,
+				`{"log":"completed","stream":"stdout","time":"2026-02-28T10:00:02Z"}`,
+			},
+			want: []decoded{
+				{"complete", "stdout", "2026-02-28T10:00:00Z"},
+				{"never completed", "stdout", "2026-02-28T10:00:01Z"},
+			},
+		},
+		{
+			// passed through unchanged
+			name:   "docker malformed json",
+			format: FormatDocker,
+			lines: []string{
+				`{"log":"cut off`,
+				`plain text`,
+				`{"log":"after\n","stream":"stdout","time":"2026-02-28T10:00:00Z"}`,
+			},
+			want: []decoded{
+				{`{"log":"cut off`, "", ""},
+				{"plain text", "", ""},
+				{"after", "stdout", "2026-02-28T10:00:00Z"},
+			},
+		},
+		{
+			name:   "cri",
+			format: FormatCRI,
+			lines: []string{
+				"2026-02-28T10:00:00.000000001Z stdout F first line",
+				"2026-02-28T10:00:01Z stderr F second",
+				"2026-02-28T10:00:02Z stdout F",
+			},
+			want: []decoded{
+				{"first line", "stdout", "2026-02-28T10:00:00.000000001Z"},
+				{"second", "stderr", "2026-02-28T10:00:01Z"},
+				{"", "stdout", "2026-02-28T10:00:02Z"},
+			},
+		},
+		{
+			name:   "cri split line",
+			format: FormatCRI,
+			lines: []string{
+				"2026-02-28T10:00:00Z stdout P a long ",
+				"2026-02-28T10:00:01Z stderr F other",
+				"2026-02-28T10:00:02Z stdout P line that was ",
+				"2026-02-28T10:00:03Z stdout F split",
+			},
+			want: []decoded{
+				{"other", "stderr", "2026-02-28T10:00:01Z"},
+				{"a long line that was split", "stdout", "2026-02-28T10:00:00Z"},
+			},
+		},
+		{
+			name:   "cri partial line at the end",
+			format: FormatCRI,
+			lines: []string{
+				"2026-02-28T10:00:00Z stdout P never ",
+				"2026-02-28T10:00:01Z stdout P completed",
+			},
+			want: []decoded{
+				{"never completed", "stdout", "2026-02-28T10:00:00Z"},
+			},
+		},
+		{
+			name:   "cri malformed",
+			format: FormatCRI,
+			lines: []string{
+				"not a timestamp stdout F message",
+				"2026-02-28T10:00:00Z",
+				"2026-02-28T10:00:01Z stdout F after",
+			},
+			want: []decoded{
+				{"not a timestamp stdout F message", "", ""},
+				{"2026-02-28T10:00:00Z", "", ""},
+				{"after", "stdout", "2026-02-28T10:00:01Z"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoder := newDecoderInput(newSliceInput(test.lines...), test.format)
+			defer decoder.Close()
+			var got []decoded
+			timeout := time.After(5 * time.Second)
+		read:
+			for {
+				select {
+				case line, ok := <-decoder.Lines():
+					if !ok {
+						break read
+					}
+					got = append(got, decoded{line.Text, line.Fields["stream"], line.Fields["time"]})
+					if want, _ := time.Parse(time.RFC3339Nano, line.Fields["time"]); !line.Time.Equal(want) {
+						t.Errorf("%q has the time %v, want %v", line.Text, line.Time, want)
+					}
+				case <-timeout:
+					t.Fatalf("the decoder did not end, read %q", got)
+				}
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("read %q, want %q", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("line %d is %q, want %q", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
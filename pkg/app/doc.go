@@ -0,0 +1,34 @@
+/*
+Package prometheuslog tails application log files and turns matching log
+lines into prometheus metrics named <application>_<environment>_<metric>.
+
+It can be embedded in another Go program:
+
+	app, err := prometheuslog.New(prometheuslog.Config{
+		Environment: "prod",
+		Applications: []prometheuslog.ApplicationConfig{
+			{Name: "myFirstApplication", LogPath: "/var/log/first.log"},
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = app.RegisterRule("timeouts", func(line string, applicationName string, registry metrics.Registry, debug bool) {
+		if strings.Contains(line, "TimeoutException") {
+			metrics.GetOrRegisterCounter("timeouts-total", registry).Inc(1)
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := app.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	defer app.Stop(context.Background())
+
+	http.Handle("/logmetrics", app.Handler())
+
+Use app.Collector() instead of app.Handler() to register the metrics with
+an existing prometheus registry.
+*/
+package prometheuslog
@@ -0,0 +1,247 @@
+package prometheuslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Explanation describes how a single log line is processed for an
+// application, step by step.
+type Explanation struct {
+	Application string            `json:"application"`
+	Line        string            `json:"line"`
+	Steps       []ExplanationStep `json:"steps"`
+}
+
+// ExplanationStep is one evaluated piece of the pipeline: the built-in
+// parsing in common.go, a rule, or a line handler.
+type ExplanationStep struct {
+	Name      string            `json:"name"`
+	Kind      string            `json:"kind"` // builtin, rule, script, func or handler
+	Skipped   string            `json:"skipped,omitempty"`
+	Prefilter *bool             `json:"prefilter,omitempty"` // nil when the rule has no prefilter
+	Regex     *bool             `json:"regex,omitempty"`     // nil when the rule has no regex
+	Captures  map[string]string `json:"captures,omitempty"`
+	Updates   []MetricUpdate    `json:"updates,omitempty"`
+}
+
+// MetricUpdate is a metric change a line would cause. Value is the counter
+// increment or the new gauge value.
+type MetricUpdate struct {
+	Metric string  `json:"metric"`
+	Type   string  `json:"type"`
+	Value  float64 `json:"value"`
+}
+
+// Explain runs line through every step of applicationName's pipeline and
+// reports what matched and which metrics would be updated. Each step runs
+// against a scratch registry, so live metrics are not changed. fields are
+// the line's input fields, such as syslog's severity, and are merged with
+// the application's labels as for a tailed line.
+func (app *App) Explain(applicationName string, line string, fields Fields) *Explanation {
+	levels := app.levels
+	lineFields := Fields{}
+	if application := app.findApplication(applicationName); application != nil {
+		levels = application.levelMatcher()
+		for name, value := range application.Labels {
+			lineFields[name] = value
+		}
+	}
+	for name, value := range fields {
+		lineFields[name] = value
+	}
+	lineFields["application"] = applicationName
+	fields = lineFields
+	app.Lock()
+	rules := app.rules
+	handlers := app.handlersFor(applicationName)
+	app.Unlock()
+
+	explanation := &Explanation{Application: applicationName, Line: line}
+
+	levelStep := ExplanationStep{Name: "level", Kind: "builtin"}
+	if level, ok := levels.match(line, fields); ok {
+		if _, ok := fields["level"]; !ok {
+			fields["level"] = level
+		}
+		levelStep.Updates = []MetricUpdate{{
+			Metric: fmt.Sprintf("%s{level=%q}", metricName(applicationName, app.Config.Environment, levelMetric), level),
+			Type:   RuleTypeCounter,
+			Value:  1,
+		}}
+	}
+	explanation.Steps = append(explanation.Steps, levelStep)
+
+	registry := metrics.NewRegistry()
+	app.CategorizeLogData(line, applicationName, &registry, false)
+	explanation.Steps = append(explanation.Steps, ExplanationStep{
+		Name:    "common.go",
+		Kind:    "builtin",
+		Updates: app.registryUpdates(registry, applicationName),
+	})
+
+	for i := range rules {
+		explanation.Steps = append(explanation.Steps, app.explainRule(&rules[i], applicationName, line, fields))
+	}
+
+	timestamp, ok := parseTimestamp(line)
+	if !ok {
+		timestamp = time.Now()
+	}
+	for i, handler := range handlers {
+		registry := metrics.NewRegistry()
+		handler.HandleLine(line, fields, timestamp, registrySink{registry})
+		explanation.Steps = append(explanation.Steps, ExplanationStep{
+			Name:    fmt.Sprintf("handler %d (%T)", i, handler),
+			Kind:    "handler",
+			Updates: app.registryUpdates(registry, applicationName),
+		})
+	}
+	return explanation
+}
+
+func (app *App) explainRule(rule *Rule, applicationName string, line string, fields Fields) ExplanationStep {
+	step := ExplanationStep{Name: rule.Name, Kind: "rule"}
+	switch {
+	case rule.Func != nil:
+		step.Kind = "func"
+	case rule.script != nil:
+		step.Kind = "script"
+	}
+	if rule.Application != "" && rule.Application != applicationName {
+		step.Skipped = fmt.Sprintf("only applies to %s", rule.Application)
+		return step
+	}
+	if !rule.fieldsMatch(fields) {
+		step.Skipped = fmt.Sprintf("only applies to lines with the fields %v", rule.Fields)
+		return step
+	}
+
+	if rule.Func == nil {
+		if rule.Contains != "" {
+			matched := strings.Contains(line, rule.Contains)
+			step.Prefilter = &matched
+			if !matched {
+				return step
+			}
+		}
+		if rule.regex != nil {
+			submatch := rule.regex.FindStringSubmatch(line)
+			matched := submatch != nil
+			step.Regex = &matched
+			if !matched {
+				return step
+			}
+			step.Captures = scriptFields(Fields{}, rule, submatch)
+		}
+	}
+
+	registry := metrics.NewRegistry()
+	rule.apply(app, line, fields, registry, false)
+	step.Updates = app.registryUpdates(registry, applicationName)
+	return step
+}
+
+// registryUpdates lists every metric in a scratch registry, by exposed name.
+func (app *App) registryUpdates(registry metrics.Registry, applicationName string) []MetricUpdate {
+	var updates []MetricUpdate
+	registry.Each(func(name string, i interface{}) {
+		update := MetricUpdate{Metric: metricName(applicationName, app.Config.Environment, name)}
+		switch metric := i.(type) {
+		case metrics.Counter:
+			update.Type, update.Value = RuleTypeCounter, float64(metric.Count())
+		case metrics.Gauge:
+			update.Type, update.Value = RuleTypeGauge, float64(metric.Value())
+		case metrics.GaugeFloat64:
+			update.Type, update.Value = RuleTypeGauge, metric.Value()
+		default:
+			return
+		}
+		updates = append(updates, update)
+	})
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Metric < updates[j].Metric })
+	return updates
+}
+
+// String formats the explanation for a terminal.
+func (explanation *Explanation) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Application: %s\nLine: %s\n\n", explanation.Application, explanation.Line)
+	for _, step := range explanation.Steps {
+		fmt.Fprintf(&b, "%s (%s)\n", step.Name, step.Kind)
+		if step.Skipped != "" {
+			fmt.Fprintf(&b, "    skipped: %s\n", step.Skipped)
+			continue
+		}
+		if step.Prefilter != nil {
+			fmt.Fprintf(&b, "    prefilter: %s\n", matchedString(*step.Prefilter))
+		}
+		if step.Regex != nil {
+			fmt.Fprintf(&b, "    regex: %s\n", matchedString(*step.Regex))
+		}
+		keys := make([]string, 0, len(step.Captures))
+		for key := range step.Captures {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "    capture %s = %q\n", key, step.Captures[key])
+		}
+		for _, update := range step.Updates {
+			if update.Type == RuleTypeCounter {
+				fmt.Fprintf(&b, "    %s %s +%g\n", update.Type, update.Metric, update.Value)
+			} else {
+				fmt.Fprintf(&b, "    %s %s = %g\n", update.Type, update.Metric, update.Value)
+			}
+		}
+	}
+	return b.String()
+}
+
+func matchedString(matched bool) string {
+	if matched {
+		return "matched"
+	}
+	return "no match"
+}
+
+// ExplainHandler returns an http.Handler for rule debugging. It takes the
+// application and line query parameters (or form values on POST), and
+// field parameters of the form name=value, and responds with the
+// Explanation as JSON. Since it runs every rule and script on the given
+// line, requests must send one of Config.IngestTokens as
+// "Authorization: Bearer <token>"; every request is refused when there are
+// none.
+func (app *App) ExplainHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.ingestAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="prometheuslog"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		applicationName := r.FormValue("application")
+		line := r.FormValue("line")
+		if applicationName == "" || line == "" {
+			http.Error(w, "application and line are required", http.StatusBadRequest)
+			return
+		}
+		r.ParseForm()
+		fields := Fields{}
+		for _, field := range r.Form["field"] {
+			name, value, ok := strings.Cut(field, "=")
+			if !ok || name == "" {
+				http.Error(w, fmt.Sprintf("field %q: expected name=value", field), http.StatusBadRequest)
+				return
+			}
+			fields[name] = value
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(app.Explain(applicationName, line, fields))
+	})
+}
@@ -0,0 +1,94 @@
+package prometheuslog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileSample is what fileStatsWorker last saw of a log, to compute growth.
+type fileSample struct {
+	size  int64
+	inode uint64
+	time  time.Time
+}
+
+// fileStatsWorker updates the file metrics of an application's log every
+// flush interval, so runaway logging can be alerted on before the disk
+// fills.
+func (application *Application) fileStatsWorker(ctx context.Context) {
+	defer application.wg.Done()
+
+	var previous fileSample
+	update := func() { previous = application.updateFileStats(ctx, previous) }
+	update()
+	ticker := time.NewTicker(application.Config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			update()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// updateFileStats sets the file metrics of the application's log. Growth
+// is the increase in size since the previous sample, or the whole size of
+// a replaced or truncated file. Nothing is set once ctx is done, so the
+// series of a removed application stay deleted.
+func (application *Application) updateFileStats(ctx context.Context, previous fileSample) fileSample {
+	logPath := application.LogPath
+	info, err := os.Stat(logPath)
+	if err != nil {
+		// between rotation and the new file being created
+		return previous
+	}
+	sample := fileSample{size: info.Size(), time: time.Now()}
+	inode, hasInode := fileInode(info)
+	sample.inode = inode
+
+	// a symlinked log is rotated next to its target
+	target := resolveLink(logPath)
+	var rotatedFiles, rotatedSize int64
+	rotated, _ := RotatedLogs(target)
+	for _, path := range rotated {
+		if path == target {
+			continue
+		}
+		if rotatedInfo, err := os.Stat(path); err == nil {
+			rotatedFiles++
+			rotatedSize += rotatedInfo.Size()
+		}
+	}
+	free, size, hasSpace := filesystemSpace(filepath.Dir(target))
+
+	application.App.Lock()
+	defer application.App.Unlock()
+	if ctx.Err() != nil {
+		return previous
+	}
+	name := application.ApplicationName
+	files := application.files
+	files.size.WithLabelValues(name).Set(float64(sample.size))
+	files.modified.WithLabelValues(name).Set(float64(info.ModTime().UnixNano()) / 1e9)
+	files.rotatedFiles.WithLabelValues(name).Set(float64(rotatedFiles))
+	files.rotatedSize.WithLabelValues(name).Set(float64(rotatedSize))
+	if hasInode {
+		files.inode.WithLabelValues(name).Set(float64(inode))
+	}
+	if hasSpace {
+		files.filesystemFree.WithLabelValues(name).Set(float64(free))
+		files.filesystemSize.WithLabelValues(name).Set(float64(size))
+	}
+	if !previous.time.IsZero() {
+		growth := sample.size - previous.size
+		if sample.inode != previous.inode || growth < 0 {
+			growth = sample.size
+		}
+		files.growth.WithLabelValues(name).Set(float64(growth) / sample.time.Sub(previous.time).Seconds())
+	}
+	return sample
+}
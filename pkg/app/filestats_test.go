@@ -0,0 +1,154 @@
+package prometheuslog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func gaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	t.Helper()
+	metric := &dto.Metric{}
+	if err := gauge.Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetGauge().GetValue()
+}
+
+func writeSizedFile(t *testing.T, path string, size int) {
+	t.Helper()
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fileStatsApplication returns an application following a link in one
+// directory to a log in another, which holds its rotated copies.
+func fileStatsApplication(t *testing.T) (*Application, string) {
+	t.Helper()
+	directory := t.TempDir()
+	logs, links := filepath.Join(directory, "logs"), filepath.Join(directory, "current")
+	for _, path := range []string{logs, links} {
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	target := filepath.Join(logs, "app.log")
+	writeSizedFile(t, target, 100)
+	writeSizedFile(t, target+".1", 10)
+	writeSizedFile(t, target+".2.gz", 20)
+	writeSizedFile(t, filepath.Join(logs, "other.log.1"), 40)
+	// not a rotated copy of the target, though it's next to the link
+	writeSizedFile(t, filepath.Join(links, "app.log.1"), 80)
+	logPath := filepath.Join(links, "app.log")
+	if err := os.Symlink(target, logPath); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApp()
+	app.files = newFileMetrics(app)
+	return app.AddApplication(0, "myapp", logPath, 1000, false), target
+}
+
+func TestUpdateFileStats(t *testing.T) {
+	application, _ := fileStatsApplication(t)
+	files := application.files
+	sample := application.updateFileStats(context.Background(), fileSample{})
+
+	if got := gaugeValue(t, files.size.WithLabelValues("myapp")); got != 100 {
+		t.Errorf("prometheuslog_log_size_bytes = %g, want 100", got)
+	}
+	if got := gaugeValue(t, files.rotatedFiles.WithLabelValues("myapp")); got != 2 {
+		t.Errorf("prometheuslog_log_rotated_files = %g, want 2", got)
+	}
+	if got := gaugeValue(t, files.rotatedSize.WithLabelValues("myapp")); got != 30 {
+		t.Errorf("prometheuslog_log_rotated_size_bytes = %g, want 30", got)
+	}
+	if inode, ok := fileInode(statFile(t, application.LogPath)); ok && gaugeValue(t, files.inode.WithLabelValues("myapp")) != float64(inode) {
+		t.Errorf("prometheuslog_log_inode = %g, want %d", gaugeValue(t, files.inode.WithLabelValues("myapp")), inode)
+	}
+	if sample.size != 100 || sample.time.IsZero() {
+		t.Errorf("sample %+v, want a size of 100", sample)
+	}
+	// growth needs a previous sample
+	if n := collectCount(files.growth); n != 0 {
+		t.Errorf("%d growth series after the first sample, want 0", n)
+	}
+}
+
+func TestUpdateFileStatsGrowth(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, target string)
+		growth float64 // bytes since the previous sample
+	}{
+		{
+			name:   "appended",
+			change: func(t *testing.T, target string) { appendLog(t, target, string(make([]byte, 50))) },
+			growth: 50,
+		},
+		{
+			// the whole of what was written since
+			name:   "truncated",
+			change: func(t *testing.T, target string) { os.Truncate(target, 30) },
+			growth: 30,
+		},
+		{
+			name: "replaced",
+			change: func(t *testing.T, target string) {
+				if err := os.Rename(target, target+".1"); err != nil {
+					t.Fatal(err)
+				}
+				writeSizedFile(t, target, 120)
+			},
+			growth: 120,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			application, target := fileStatsApplication(t)
+			previous := application.updateFileStats(context.Background(), fileSample{})
+			// a second ago, for a growth per second close to the bytes
+			previous.time = previous.time.Add(-time.Second)
+			test.change(t, target)
+			sample := application.updateFileStats(context.Background(), previous)
+
+			elapsed := sample.time.Sub(previous.time).Seconds()
+			want := test.growth / elapsed
+			if got := gaugeValue(t, application.files.growth.WithLabelValues("myapp")); got < want*0.999 || got > want*1.001 {
+				t.Errorf("prometheuslog_log_growth_bytes_per_second = %g, want %g", got, want)
+			}
+		})
+	}
+}
+
+func TestUpdateFileStatsStopped(t *testing.T) {
+	application, _ := fileStatsApplication(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	application.updateFileStats(ctx, fileSample{})
+	if n := collectCount(application.files.size); n != 0 {
+		t.Errorf("%d size series once stopped, want 0", n)
+	}
+}
+
+func statFile(t *testing.T, path string) os.FileInfo {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func collectCount(collector prometheus.Collector) int {
+	metrics := make(chan prometheus.Metric, 10)
+	collector.Collect(metrics)
+	close(metrics)
+	return len(metrics)
+}
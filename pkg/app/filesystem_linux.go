@@ -0,0 +1,49 @@
+package prometheuslog
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// unreliableNotify reports whether inotify may miss changes to files in
+// directory: changes made by other hosts on network filesystems, or through
+// another layer of an overlay filesystem.
+func unreliableNotify(directory string) (string, bool) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(directory, &stat); err != nil {
+		return "", false
+	}
+	switch uint32(stat.Type) {
+	case unix.NFS_SUPER_MAGIC:
+		return "nfs", true
+	case unix.SMB_SUPER_MAGIC, unix.SMB2_SUPER_MAGIC, unix.CIFS_SUPER_MAGIC:
+		return "cifs", true
+	case unix.CEPH_SUPER_MAGIC:
+		return "ceph", true
+	case unix.FUSE_SUPER_MAGIC:
+		return "fuse", true
+	case unix.OVERLAYFS_SUPER_MAGIC:
+		return "overlay", true
+	}
+	return "", false
+}
+
+// filesystemSpace returns the bytes available to unprivileged users and the
+// total size of the filesystem holding directory.
+func filesystemSpace(directory string) (free uint64, size uint64, ok bool) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(directory, &stat); err != nil {
+		return 0, 0, false
+	}
+	return stat.Bavail * uint64(stat.Bsize), stat.Blocks * uint64(stat.Bsize), true
+}
+
+func fileInode(info os.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return stat.Ino, true
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package prometheuslog
+
+import "os"
+
+// unreliableNotify reports whether file notifications may miss changes to
+// files in directory. It is only known on Linux.
+func unreliableNotify(directory string) (string, bool) {
+	return "", false
+}
+
+// filesystemSpace returns the free and total bytes of the filesystem
+// holding directory. It is only known on Linux.
+func filesystemSpace(directory string) (free uint64, size uint64, ok bool) {
+	return 0, 0, false
+}
+
+// fileInode is only known on Linux.
+func fileInode(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,339 @@
+package prometheuslog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	fingerprintLength = 256
+	fileReadSize      = 64 * 1024
+)
+
+// fileMetrics counts the rotations and truncations of every followed log,
+// records how it is watched, and holds the file statistics updated by
+// fileStatsWorker, by application.
+type fileMetrics struct {
+	rotations      *prometheus.CounterVec
+	truncations    *prometheus.CounterVec
+	watchMode      *prometheus.GaugeVec
+	watchFallbacks *prometheus.CounterVec
+
+	size           *prometheus.GaugeVec
+	growth         *prometheus.GaugeVec
+	inode          *prometheus.GaugeVec
+	modified       *prometheus.GaugeVec
+	rotatedFiles   *prometheus.GaugeVec
+	rotatedSize    *prometheus.GaugeVec
+	filesystemFree *prometheus.GaugeVec
+	filesystemSize *prometheus.GaugeVec
+}
+
+func newFileMetrics(app *App) *fileMetrics {
+	files := &fileMetrics{
+		rotations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "prometheuslog_log_rotations_total",
+			Help: "Times the followed log was replaced by a new file, which was opened after reading the rest of the old one.",
+		}, []string{"application"}),
+		truncations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "prometheuslog_log_truncations_total",
+			Help: "Times the followed log was truncated in place, e.g. by copytruncate, and read again from the beginning.",
+		}, []string{"application"}),
+		watchMode: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "prometheuslog_log_watch_mode",
+			Help: "How the followed log is watched: 1 for the mode in use, inotify or poll.",
+		}, []string{"application", "mode"}),
+		watchFallbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "prometheuslog_log_watch_fallbacks_total",
+			Help: "Times the followed log was polled because inotify was unavailable, e.g. its limits were exhausted.",
+		}, []string{"application"}),
+		size:           newFileGauge("prometheuslog_log_size_bytes", "Size of the followed log."),
+		growth:         newFileGauge("prometheuslog_log_growth_bytes_per_second", "Bytes written to the followed log per second since the last update, including across rotation."),
+		inode:          newFileGauge("prometheuslog_log_inode", "Inode number of the followed log."),
+		modified:       newFileGauge("prometheuslog_log_modified_timestamp_seconds", "Last modification time of the followed log."),
+		rotatedFiles:   newFileGauge("prometheuslog_log_rotated_files", "Rotated copies of the followed log next to it, or to its target when it is a symlink, e.g. app.log.1 and app.log.2.gz."),
+		rotatedSize:    newFileGauge("prometheuslog_log_rotated_size_bytes", "Total size of the rotated copies of the followed log."),
+		filesystemFree: newFileGauge("prometheuslog_log_filesystem_free_bytes", "Bytes available to unprivileged users on the filesystem holding the followed log."),
+		filesystemSize: newFileGauge("prometheuslog_log_filesystem_size_bytes", "Size of the filesystem holding the followed log."),
+	}
+	app.collector.MustRegister(files.rotations, files.truncations, files.watchMode, files.watchFallbacks,
+		files.size, files.growth, files.inode, files.modified, files.rotatedFiles, files.rotatedSize, files.filesystemFree, files.filesystemSize)
+	return files
+}
+
+func newFileGauge(name string, help string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, []string{"application"})
+}
+
+func (files *fileMetrics) setWatchMode(applicationName string, mode string) {
+	files.watchMode.DeletePartialMatch(prometheus.Labels{"application": applicationName})
+	files.watchMode.WithLabelValues(applicationName, mode).Set(1)
+}
+
+// delete removes the series of an application that is no longer followed.
+func (files *fileMetrics) delete(applicationName string) {
+	labels := prometheus.Labels{"application": applicationName}
+	for _, vector := range []*prometheus.MetricVec{
+		files.rotations.MetricVec, files.truncations.MetricVec, files.watchMode.MetricVec, files.watchFallbacks.MetricVec,
+		files.size.MetricVec, files.growth.MetricVec, files.inode.MetricVec, files.modified.MetricVec,
+		files.rotatedFiles.MetricVec, files.rotatedSize.MetricVec, files.filesystemFree.MetricVec, files.filesystemSize.MetricVec,
+	} {
+		vector.DeletePartialMatch(labels)
+	}
+}
+
+// fileInput follows a log file without losing lines across rotation. When
+// the path names a new file (rename and create), the rest of the old file is
+// read before the new one is opened and read from the beginning. When the
+// file shrinks, or its first bytes change (copytruncate followed by new
+// writes), it is read again from the beginning. A partial last line is
+// delivered when the file is switched. A symlinked log is followed to its
+// target, and repointing the link is a rotation. The file is looked at
+// again when its watch wakes it, and every watch interval.
+type fileInput struct {
+	path        string
+	target      string // the file path links to, or path
+	watch       *fileWatch
+	logger      *slog.Logger
+	rotations   prometheus.Counter
+	truncations prometheus.Counter
+
+	lines chan Line
+	done  chan struct{}
+	once  sync.Once
+	err   error // set before lines is closed
+
+	file        *os.File
+	offset      int64
+	fingerprint []byte // the first bytes of the file, up to fingerprintLength
+	pending     []byte // a line without its newline yet
+	buffer      []byte
+}
+
+func newFileInput(path string, fromStart bool, watch *fileWatch, logger *slog.Logger, rotations prometheus.Counter, truncations prometheus.Counter) (*fileInput, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	input := &fileInput{
+		path:        path,
+		target:      resolveLink(path),
+		watch:       watch,
+		logger:      logger,
+		rotations:   rotations,
+		truncations: truncations,
+		lines:       make(chan Line),
+		done:        make(chan struct{}),
+		file:        file,
+		buffer:      make([]byte, fileReadSize),
+	}
+	if !fromStart {
+		if input.offset, err = file.Seek(0, io.SeekEnd); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if input.target != path {
+		logger.Info("following log link", "log", path, "target", input.target)
+	}
+	input.updateFingerprint()
+	go input.follow()
+	return input, nil
+}
+
+func (input *fileInput) follow() {
+	defer close(input.lines)
+	defer func() { input.file.Close() }()
+	defer input.watch.stop()
+
+	for {
+		// check first, so a file truncated and written past the old
+		// offset isn't read from the middle
+		if !input.check() || !input.readToEnd() {
+			return
+		}
+		select {
+		case <-input.watch.wake:
+		case <-time.After(input.watch.interval):
+		case <-input.done:
+			return
+		}
+	}
+}
+
+// readToEnd delivers every complete line up to the end of the file. It
+// returns false when the input is closed or fails.
+func (input *fileInput) readToEnd() bool {
+	for {
+		n, err := input.file.Read(input.buffer)
+		if n > 0 {
+			input.offset += int64(n)
+			if !input.deliver(input.buffer[:n]) {
+				return false
+			}
+		}
+		if err == io.EOF {
+			input.updateFingerprint()
+			return true
+		}
+		if err != nil {
+			input.err = err
+			return false
+		}
+	}
+}
+
+// deliver appends data to the pending line and sends every line it
+// completes. A line longer than maxLineLength is sent in pieces.
+func (input *fileInput) deliver(data []byte) bool {
+	input.pending = append(input.pending, data...)
+	start := 0
+	for {
+		end := bytes.IndexByte(input.pending[start:], '\n')
+		if end < 0 {
+			break
+		}
+		if !input.send(input.pending[start : start+end]) {
+			return false
+		}
+		start += end + 1
+	}
+	input.pending = append(input.pending[:0], input.pending[start:]...)
+	if len(input.pending) >= maxLineLength {
+		return input.flush()
+	}
+	return true
+}
+
+// flush sends the pending partial line, if any.
+func (input *fileInput) flush() bool {
+	if len(input.pending) == 0 {
+		return true
+	}
+	ok := input.send(input.pending)
+	input.pending = input.pending[:0]
+	return ok
+}
+
+func (input *fileInput) send(line []byte) bool {
+	select {
+	case input.lines <- Line{Text: string(bytes.TrimSuffix(line, []byte("\r")))}:
+		return true
+	case <-input.done:
+		return false
+	}
+}
+
+// check looks for rotation and truncation at the end of the file.
+func (input *fileInput) check() bool {
+	current, err := input.file.Stat()
+	if err != nil {
+		input.err = err
+		return false
+	}
+	latest, err := os.Stat(input.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// moved away and not created again yet; the writer may still be
+		// writing to the old file
+		return true
+	case err != nil:
+		input.logger.Warn("unable to check log for rotation", "log", input.path, "error", err)
+		return true
+	case !os.SameFile(current, latest):
+		return input.reopen()
+	case current.Size() < input.offset || input.fingerprintChanged():
+		return input.rewind()
+	}
+	return true
+}
+
+// reopen finishes reading the old file and switches to the new one.
+func (input *fileInput) reopen() bool {
+	if !input.readToEnd() || !input.flush() {
+		return false
+	}
+	file, err := os.Open(input.path)
+	if err != nil {
+		// e.g. removed again already; keep the old file and retry
+		input.logger.Warn("unable to open rotated log", "log", input.path, "error", err)
+		return true
+	}
+	input.file.Close()
+	input.file, input.offset, input.fingerprint = file, 0, nil
+	input.rotations.Inc()
+
+	target := resolveLink(input.path)
+	if target == input.target {
+		input.logger.Info("log rotated", "log", input.path)
+		return true
+	}
+	input.logger.Info("log link repointed", "log", input.path, "from", input.target, "to", target)
+	input.retarget(target)
+	return true
+}
+
+// retarget watches the directory of a link's new target instead of the
+// old one's.
+func (input *fileInput) retarget(target string) {
+	directory, previous := filepath.Dir(input.path), filepath.Dir(input.target)
+	input.target = target
+	if filepath.Dir(target) == previous {
+		return
+	}
+	if previous != directory {
+		input.watch.unwatch(previous)
+	}
+	if err := input.watch.watch(filepath.Dir(target)); err != nil {
+		input.logger.Warn("unable to watch log link target", "log", input.path, "target", target, "error", err)
+	}
+}
+
+// rewind reads a truncated file again from the beginning.
+func (input *fileInput) rewind() bool {
+	if !input.flush() {
+		return false
+	}
+	if _, err := input.file.Seek(0, io.SeekStart); err != nil {
+		input.err = err
+		return false
+	}
+	input.offset, input.fingerprint = 0, nil
+	input.truncations.Inc()
+	input.logger.Info("log truncated", "log", input.path)
+	return true
+}
+
+func (input *fileInput) fingerprintChanged() bool {
+	if len(input.fingerprint) == 0 {
+		return false
+	}
+	current := make([]byte, len(input.fingerprint))
+	n, _ := input.file.ReadAt(current, 0)
+	return !bytes.Equal(current[:n], input.fingerprint)
+}
+
+// updateFingerprint records the first bytes read so far, until there are
+// fingerprintLength of them.
+func (input *fileInput) updateFingerprint() {
+	length := min(input.offset, fingerprintLength)
+	if int64(len(input.fingerprint)) >= length {
+		return
+	}
+	fingerprint := make([]byte, length)
+	n, _ := input.file.ReadAt(fingerprint, 0)
+	input.fingerprint = fingerprint[:n]
+}
+
+func (input *fileInput) Lines() <-chan Line { return input.lines }
+func (input *fileInput) Err() error         { return input.err }
+func (input *fileInput) Close()             { input.once.Do(func() { close(input.done) }) }
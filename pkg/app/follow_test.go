@@ -0,0 +1,207 @@
+package prometheuslog
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// followLog follows a new log file with polling, from its beginning.
+func followLog(t *testing.T, content string) (*Application, Input, string) {
+	t.Helper()
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(logPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	app := NewApp()
+	app.files = newFileMetrics(app)
+	application := app.AddApplication(0, "myapp", logPath, 1000, false)
+	// long enough that the changes below are made between two checks
+	application.Watch, application.PollInterval = WatchPoll, 200*time.Millisecond
+	application.fromStart = true
+	input, err := application.openInput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(input.Close)
+	return application, input, logPath
+}
+
+// expectLines reads the next lines of input and compares them to want.
+func expectLines(t *testing.T, input Input, want ...string) {
+	t.Helper()
+	expectLinesWithin(t, input, 5*time.Second, want...)
+}
+
+// expectLinesWithin is expectLines, failing when a line takes longer than
+// timeout to be read.
+func expectLinesWithin(t *testing.T, input Input, timeout time.Duration, want ...string) {
+	t.Helper()
+	for _, text := range want {
+		select {
+		case line, ok := <-input.Lines():
+			if !ok {
+				t.Fatalf("input ended before %q: %v", text, input.Err())
+			}
+			if line.Text != text {
+				t.Fatalf("read %q, want %q", line.Text, text)
+			}
+		case <-time.After(timeout):
+			t.Fatalf("%q was not read", text)
+		}
+	}
+}
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	t.Helper()
+	metric := &dto.Metric{}
+	if err := counter.Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func TestFileInputRotation(t *testing.T) {
+	application, input, logPath := followLog(t, "first\n")
+	expectLines(t, input, "first")
+
+	// lines written to the old file after the last read, including a
+	// partial one, come before the new file's
+	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("second\npartial")
+	if err := os.Rename(logPath, logPath+".1"); err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(" line\n")
+	file.Close()
+	if err := os.WriteFile(logPath, []byte("third\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, input, "second", "partial line", "third")
+
+	if got := counterValue(t, application.files.rotations.WithLabelValues("myapp")); got != 1 {
+		t.Errorf("prometheuslog_log_rotations_total = %g, want 1", got)
+	}
+	if got := counterValue(t, application.files.truncations.WithLabelValues("myapp")); got != 0 {
+		t.Errorf("prometheuslog_log_truncations_total = %g, want 0", got)
+	}
+}
+
+func TestFileInputTruncation(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		lines  []string
+	}{
+		// copytruncate, then fewer bytes written than were read
+		{name: "shrink", before: "first\nsecond\n", after: "third\n", lines: []string{"first", "second", "third"}},
+		// copytruncate, then as many bytes written as were read, which
+		// only the fingerprint catches
+		{name: "same size", before: "first\n", after: "third\n", lines: []string{"first", "third"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			application, input, logPath := followLog(t, test.before)
+			expectLines(t, input, test.lines[:len(test.lines)-1]...)
+
+			// written over in place, so the size never drops below what
+			// was read in the same size case
+			file, err := os.OpenFile(logPath, os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.WriteAt([]byte(test.after), 0)
+			file.Truncate(int64(len(test.after)))
+			file.Close()
+			expectLines(t, input, test.lines[len(test.lines)-1])
+
+			if got := counterValue(t, application.files.truncations.WithLabelValues("myapp")); got != 1 {
+				t.Errorf("prometheuslog_log_truncations_total = %g, want 1", got)
+			}
+			if got := counterValue(t, application.files.rotations.WithLabelValues("myapp")); got != 0 {
+				t.Errorf("prometheuslog_log_rotations_total = %g, want 0", got)
+			}
+		})
+	}
+}
+
+func TestFileInputSymlink(t *testing.T) {
+	for _, mode := range []string{WatchPoll, WatchInotify} {
+		t.Run(mode, func(t *testing.T) {
+			// current.log links to the day's log, in a directory per day
+			directory := t.TempDir()
+			oldTarget := filepath.Join(directory, "2026-10-16", "app.log")
+			newTarget := filepath.Join(directory, "2026-10-17", "app.log")
+			for _, target := range []string{oldTarget, newTarget} {
+				if err := os.Mkdir(filepath.Dir(target), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(oldTarget, []byte("first\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			logPath := filepath.Join(directory, "current.log")
+			if err := os.Symlink(oldTarget, logPath); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			application, input, err := watchPath(t, logPath, newFileWatcher(ctx, slog.Default()), mode)
+			if err != nil {
+				t.Skip(err)
+			}
+			// less than the check interval, so inotify events are needed
+			within := notifyCheckInterval / 2
+			expectLinesWithin(t, input, within, "first")
+
+			// the rest of the old target is read before the new one
+			time.Sleep(100 * time.Millisecond)
+			appendLog(t, oldTarget, "second\npartial")
+			if err := os.WriteFile(newTarget, []byte("third\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			repointed := filepath.Join(directory, "current.log.new")
+			if err := os.Symlink(newTarget, repointed); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(repointed, logPath); err != nil {
+				t.Fatal(err)
+			}
+			expectLinesWithin(t, input, within, "second", "partial", "third")
+
+			// the new target is watched, and the old one no longer followed
+			appendLog(t, newTarget, "fourth\n")
+			expectLinesWithin(t, input, within, "fourth")
+			appendLog(t, oldTarget, "stale\n")
+			appendLog(t, newTarget, "fifth\n")
+			expectLinesWithin(t, input, within, "fifth")
+
+			if got := counterValue(t, application.files.rotations.WithLabelValues("myapp")); got != 1 {
+				t.Errorf("prometheuslog_log_rotations_total = %g, want 1", got)
+			}
+		})
+	}
+}
+
+func appendLog(t *testing.T, logPath string, text string) {
+	t.Helper()
+	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,175 @@
+package prometheuslog
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultGraphitePrefix  = "{application}.{environment}"
+	defaultGraphiteTimeout = 10 * time.Second
+)
+
+// GraphiteConfig configures sending metrics to a Graphite (carbon) plaintext
+// listener over TCP. It is disabled when Address is empty.
+type GraphiteConfig struct {
+	Address  string        // host:port, usually port 2003
+	Prefix   string        // {application} and {environment} are replaced; default {application}.{environment}
+	Interval time.Duration // default Config.FlushInterval
+	Timeout  time.Duration // to connect and to send, default 10s
+}
+
+func (config *GraphiteConfig) setDefaults(flushInterval time.Duration) {
+	if config.Prefix == "" {
+		config.Prefix = defaultGraphitePrefix
+	}
+	if config.Interval <= 0 {
+		config.Interval = flushInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultGraphiteTimeout
+	}
+}
+
+// graphiteWriter sends every metric of every application on one connection,
+// which is reopened after an error.
+type graphiteWriter struct {
+	app    *App
+	config GraphiteConfig
+	conn   net.Conn
+
+	metricsSent prometheus.Counter
+	sendsFailed prometheus.Counter
+}
+
+func newGraphiteWriter(app *App) *graphiteWriter {
+	writer := &graphiteWriter{
+		app:    app,
+		config: app.Config.Graphite,
+		metricsSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheuslog_graphite_metrics_sent_total",
+			Help: "Metrics sent to graphite.",
+		}),
+		sendsFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheuslog_graphite_sends_failed_total",
+			Help: "Flushes that could not be sent to graphite.",
+		}),
+	}
+	app.collector.MustRegister(writer.metricsSent, writer.sendsFailed)
+	return writer
+}
+
+// graphiteWorker sends every interval, and once more when ctx is done.
+func (app *App) graphiteWorker(ctx context.Context) {
+	defer app.wg.Done()
+
+	writer := newGraphiteWriter(app)
+	defer writer.close()
+	ticker := time.NewTicker(writer.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			writer.send(ctx)
+		case <-ctx.Done():
+			final, cancel := app.finalContext()
+			writer.send(final)
+			cancel()
+			return
+		}
+	}
+}
+
+func (writer *graphiteWriter) send(ctx context.Context) {
+	data, count := writer.app.graphiteLines(writer.config.Prefix, time.Now())
+	if count == 0 {
+		return
+	}
+	if err := writer.write(ctx, data); err != nil {
+		writer.close()
+		writer.sendsFailed.Inc()
+		writer.app.Config.Logger.Error("graphite: unable to send metrics", "address", writer.config.Address, "error", err)
+		return
+	}
+	writer.metricsSent.Add(float64(count))
+}
+
+// write sends data, giving up after the timeout or when ctx is done.
+func (writer *graphiteWriter) write(ctx context.Context, data []byte) error {
+	deadline := time.Now().Add(writer.config.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if writer.conn == nil {
+		dialer := net.Dialer{Deadline: deadline}
+		conn, err := dialer.DialContext(ctx, "tcp", writer.config.Address)
+		if err != nil {
+			return err
+		}
+		writer.conn = conn
+	}
+	writer.conn.SetWriteDeadline(deadline)
+	w := bufio.NewWriter(writer.conn)
+	w.Write(data)
+	return w.Flush()
+}
+
+func (writer *graphiteWriter) close() {
+	if writer.conn != nil {
+		writer.conn.Close()
+		writer.conn = nil
+	}
+}
+
+// graphiteLines formats every metric of every application in the graphite
+// plaintext protocol: "<prefix>.<metric> <value> <unix timestamp>". The
+// application is named as in prometheus metric names, and its labels, such
+// as a discovered container's namespace, pod and container, are sent as
+// graphite tags: "<prefix>.<metric>;namespace=payments <value> <timestamp>".
+// The level counts are tagged with their level, as log_messages_total;level=error.
+func (app *App) graphiteLines(prefix string, now time.Time) ([]byte, int) {
+	app.Lock()
+	applications := app.Applications
+	app.Unlock()
+
+	var b strings.Builder
+	count := 0
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	for _, application := range applications {
+		path := strings.NewReplacer(
+			"{application}", flattenKey(application.exportedName()),
+			"{environment}", flattenKey(app.Config.Environment),
+		).Replace(prefix)
+		var tags strings.Builder
+		labelNames, labelValues := sortedLabels(application.Labels)
+		for i, labelName := range labelNames {
+			if labelValues[i] != "" {
+				fmt.Fprintf(&tags, ";%s=%s", graphiteTag.Replace(labelName), graphiteTag.Replace(labelValues[i]))
+			}
+		}
+		write := func(name string, tags string, value float64) {
+			b.WriteString(strings.TrimPrefix(path+"."+flattenKey(name), "."))
+			b.WriteString(tags)
+			fmt.Fprintf(&b, " %s %s\n", strconv.FormatFloat(value, 'f', -1, 64), timestamp)
+			count++
+		}
+		eachValue(application.MetricsRegistry, func(name string, value float64) {
+			write(name, tags.String(), value)
+		})
+		application.levelValues(func(name string, level string, value float64) {
+			write(name, tags.String()+";level="+level, value)
+		})
+	}
+	return []byte(b.String()), count
+}
+
+// graphiteTag replaces the characters graphite doesn't allow in tag names
+// and values.
+var graphiteTag = strings.NewReplacer(";", "_", "!", "_", "^", "_", "=", "_", "~", "_", " ", "_")
@@ -0,0 +1,87 @@
+package prometheuslog
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// outputApp starts an App with one application having an errors counter
+// of 3 and the given labels.
+func outputApp(t *testing.T, labels map[string]string) *App {
+	t.Helper()
+	app, err := New(Config{IngestTokens: []string{"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	application := app.AddApplication(0, "myapp", IngestPath, 1000, false)
+	application.Labels = labels
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.Stop(context.Background()) })
+	metrics.GetOrRegisterCounter("errors-total", application.MetricsRegistry).Inc(3)
+	return app
+}
+
+func TestGraphite(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- ""
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	app := outputApp(t, map[string]string{"team": "pay ments;eu", "pod": "api-1"})
+	app.Config.Graphite = GraphiteConfig{Address: listener.Addr().String()}
+	app.Config.Graphite.setDefaults(time.Hour)
+	writer := newGraphiteWriter(app)
+	before := time.Now().Unix()
+	writer.send(context.Background())
+	after := time.Now().Unix()
+	writer.close()
+
+	var data string
+	select {
+	case data = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was sent")
+	}
+	var found []string
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		if strings.HasPrefix(line, "myapp.prod.errors_total;") {
+			found = strings.Fields(line)
+		}
+	}
+	if len(found) != 3 {
+		t.Fatalf("myapp.prod.errors_total was not sent: %q", data)
+	}
+	if want := "myapp.prod.errors_total;pod=api-1;team=pay_ments_eu"; found[0] != want {
+		t.Errorf("path %q, want %q", found[0], want)
+	}
+	if found[1] != "3" {
+		t.Errorf("value %s, want 3", found[1])
+	}
+	if timestamp, _ := strconv.ParseInt(found[2], 10, 64); timestamp < before || timestamp > after {
+		t.Errorf("timestamp %d, want between %d and %d", timestamp, before, after)
+	}
+	if got := counterValue(t, writer.metricsSent); got != float64(strings.Count(data, "\n")) {
+		t.Errorf("%g metrics sent, want %d", got, strings.Count(data, "\n"))
+	}
+}
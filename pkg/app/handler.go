@@ -0,0 +1,126 @@
+package prometheuslog
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Fields are values parsed from a log line before it reaches the rules and
+// line handlers. "application" is always set.
+type Fields map[string]string
+
+// MetricsSink updates metrics in an application's registry. Metric names
+// follow the same rules as in common.go: dashes become underscores and the
+// name is prefixed with <application>_<environment>_.
+type MetricsSink interface {
+	IncCounter(name string, delta int64)
+	SetGauge(name string, value float64)
+	Registry() metrics.Registry
+}
+
+// LineHandler is a custom parser for lines too complex for a declarative
+// rule. HandleLine is called once per log line, after the rules. timestamp
+// is parsed from the start of the line, or is the time the line was read.
+type LineHandler interface {
+	HandleLine(line string, fields Fields, timestamp time.Time, sink MetricsSink)
+}
+
+// LineHandlerFunc adapts an ordinary function to a LineHandler.
+type LineHandlerFunc func(line string, fields Fields, timestamp time.Time, sink MetricsSink)
+
+func (f LineHandlerFunc) HandleLine(line string, fields Fields, timestamp time.Time, sink MetricsSink) {
+	f(line, fields, timestamp, sink)
+}
+
+// AllApplications registers a line handler for every application.
+const AllApplications = "*"
+
+var (
+	lineHandlersMu sync.Mutex
+	lineHandlers   = map[string][]LineHandler{}
+)
+
+// RegisterLineHandler registers a handler for the application with the given
+// name, or for every application with AllApplications. It is meant to be
+// called from the init function of a package compiled into a custom
+// prometheuslog binary; handlers registered this way apply to every App.
+func RegisterLineHandler(applicationName string, handler LineHandler) {
+	lineHandlersMu.Lock()
+	defer lineHandlersMu.Unlock()
+	lineHandlers[applicationName] = append(lineHandlers[applicationName], handler)
+}
+
+// AddLineHandler registers a handler with this App only. It must be called
+// before Start.
+func (app *App) AddLineHandler(applicationName string, handler LineHandler) {
+	app.Lock()
+	defer app.Unlock()
+	if app.lineHandlers == nil {
+		app.lineHandlers = map[string][]LineHandler{}
+	}
+	app.lineHandlers[applicationName] = append(app.lineHandlers[applicationName], handler)
+}
+
+// handlersFor returns the global and App handlers for an application.
+// The caller must hold the App lock.
+func (app *App) handlersFor(applicationName string) []LineHandler {
+	lineHandlersMu.Lock()
+	defer lineHandlersMu.Unlock()
+
+	var handlers []LineHandler
+	for _, registered := range []map[string][]LineHandler{lineHandlers, app.lineHandlers} {
+		handlers = append(handlers, registered[AllApplications]...)
+		handlers = append(handlers, registered[applicationName]...)
+	}
+	return handlers
+}
+
+type registrySink struct {
+	registry metrics.Registry
+}
+
+func (sink registrySink) IncCounter(name string, delta int64) {
+	counter := metrics.GetOrRegisterCounter(name, sink.registry)
+	counter.Inc(delta)
+}
+
+func (sink registrySink) SetGauge(name string, value float64) {
+	meter := metrics.GetOrRegisterGaugeFloat64(name, sink.registry)
+	meter.Update(value)
+}
+
+func (sink registrySink) Registry() metrics.Registry {
+	return sink.registry
+}
+
+// timestampLayouts are the line prefixes recognised as the log timestamp.
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05,000",
+	"2006.01.02 15:04:05,000",
+	"2006-01-02 15:04:05.000",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05",
+	"2006.01.02 15:04:05",
+}
+
+// parseTimestamp parses the timestamp at the start of a log line.
+func parseTimestamp(line string) (time.Time, bool) {
+	for _, layout := range timestampLayouts {
+		prefix := line
+		if len(prefix) > len(layout) {
+			prefix = prefix[:len(layout)]
+		}
+		if strings.HasSuffix(layout, "Z07:00") {
+			if i := strings.IndexByte(line, ' '); i > 0 {
+				prefix = line[:i]
+			}
+		}
+		if timestamp, err := time.ParseInLocation(layout, prefix, time.Local); err == nil {
+			return timestamp, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,189 @@
+package prometheuslog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultInfluxDBApplicationTag = "application"
+	defaultInfluxDBEnvironmentTag = "environment"
+	defaultInfluxDBTimeout        = 10 * time.Second
+)
+
+// InfluxDBConfig configures writing metrics in the InfluxDB line protocol
+// over HTTP. Each metric is a measurement with a single "value" field,
+// tagged with the application and environment. It is disabled when URL is
+// empty.
+type InfluxDBConfig struct {
+	URL            string            // write endpoint, e.g. http://influxdb:8086/write?db=metrics or http://influxdb:8086/api/v2/write?org=o&bucket=b
+	Token          string            // sent as "Authorization: Token <token>" when set
+	Prefix         string            // prepended to every measurement name
+	ApplicationTag string            // tag holding the application name, default application
+	EnvironmentTag string            // tag holding the environment, default environment
+	Tags           map[string]string // added to every point
+	Interval       time.Duration     // default Config.FlushInterval
+	Timeout        time.Duration     // per request, default 10s
+}
+
+func (config *InfluxDBConfig) setDefaults(flushInterval time.Duration) {
+	if config.ApplicationTag == "" {
+		config.ApplicationTag = defaultInfluxDBApplicationTag
+	}
+	if config.EnvironmentTag == "" {
+		config.EnvironmentTag = defaultInfluxDBEnvironmentTag
+	}
+	if config.Interval <= 0 {
+		config.Interval = flushInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultInfluxDBTimeout
+	}
+}
+
+type influxDBWriter struct {
+	app    *App
+	config InfluxDBConfig
+	client *http.Client
+
+	pointsSent   prometheus.Counter
+	writesFailed prometheus.Counter
+}
+
+func newInfluxDBWriter(app *App) *influxDBWriter {
+	writer := &influxDBWriter{
+		app:    app,
+		config: app.Config.InfluxDB,
+		client: &http.Client{Timeout: app.Config.InfluxDB.Timeout},
+		pointsSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheuslog_influxdb_points_sent_total",
+			Help: "Points accepted by InfluxDB.",
+		}),
+		writesFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheuslog_influxdb_writes_failed_total",
+			Help: "Write requests that failed or were rejected by InfluxDB.",
+		}),
+	}
+	app.collector.MustRegister(writer.pointsSent, writer.writesFailed)
+	return writer
+}
+
+// influxDBWorker writes every interval, and once more when ctx is done.
+func (app *App) influxDBWorker(ctx context.Context) {
+	defer app.wg.Done()
+
+	writer := newInfluxDBWriter(app)
+	ticker := time.NewTicker(writer.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			writer.send(ctx)
+		case <-ctx.Done():
+			final, cancel := app.finalContext()
+			writer.send(final)
+			cancel()
+			return
+		}
+	}
+}
+
+func (writer *influxDBWriter) send(ctx context.Context) {
+	data, count := writer.app.influxDBLines(writer.config, time.Now())
+	if count == 0 {
+		return
+	}
+	if err := writer.post(ctx, data); err != nil {
+		writer.writesFailed.Inc()
+		writer.app.Config.Logger.Error("influxdb: unable to write metrics", "url", writer.config.URL, "error", err)
+		return
+	}
+	writer.pointsSent.Add(float64(count))
+}
+
+func (writer *influxDBWriter) post(ctx context.Context, data []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, writer.config.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if writer.config.Token != "" {
+		request.Header.Set("Authorization", "Token "+writer.config.Token)
+	}
+	response, err := writer.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("server returned %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+var (
+	influxDBMeasurement = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxDBTag         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// influxDBLines formats every metric of every application in the line
+// protocol: "<prefix><metric>,<tags> value=<value> <unix nanoseconds>". The
+// application tag is the application named as in prometheus metric names,
+// and the application's labels are tags too. The level counts are tagged
+// with their level, as log_messages_total,level=error.
+func (app *App) influxDBLines(config InfluxDBConfig, now time.Time) ([]byte, int) {
+	app.Lock()
+	applications := app.Applications
+	app.Unlock()
+
+	names := make([]string, 0, len(config.Tags))
+	for name := range config.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var extraTags strings.Builder
+	for _, name := range names {
+		if config.Tags[name] != "" {
+			fmt.Fprintf(&extraTags, ",%s=%s", influxDBTag.Replace(name), influxDBTag.Replace(config.Tags[name]))
+		}
+	}
+
+	var b strings.Builder
+	count := 0
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+	for _, application := range applications {
+		tags := fmt.Sprintf(",%s=%s,%s=%s",
+			influxDBTag.Replace(config.ApplicationTag), influxDBTag.Replace(flattenKey(application.exportedName())),
+			influxDBTag.Replace(config.EnvironmentTag), influxDBTag.Replace(app.Config.Environment))
+		labelNames, labelValues := sortedLabels(application.Labels)
+		for i, labelName := range labelNames {
+			if labelValues[i] != "" {
+				tags += fmt.Sprintf(",%s=%s", influxDBTag.Replace(labelName), influxDBTag.Replace(labelValues[i]))
+			}
+		}
+		tags += extraTags.String()
+		write := func(name string, tags string, value float64) {
+			b.WriteString(influxDBMeasurement.Replace(config.Prefix + flattenKey(name)))
+			b.WriteString(tags)
+			fmt.Fprintf(&b, " value=%s %s\n", strconv.FormatFloat(value, 'f', -1, 64), timestamp)
+			count++
+		}
+		eachValue(application.MetricsRegistry, func(name string, value float64) {
+			write(name, tags, value)
+		})
+		application.levelValues(func(name string, level string, value float64) {
+			write(name, tags+",level="+level, value)
+		})
+	}
+	return []byte(b.String()), count
+}
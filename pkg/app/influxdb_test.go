@@ -0,0 +1,105 @@
+package prometheuslog
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestInfluxDB(t *testing.T) {
+	var mu sync.Mutex
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests, bodies = append(requests, r), append(bodies, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	app := outputApp(t, map[string]string{"team": "pay ments,eu", "pod": "api=1"})
+	app.Config.InfluxDB = InfluxDBConfig{URL: server.URL + "/write?db=metrics", Token: "secret", Prefix: "log ", Tags: map[string]string{"site": "edge 01"}}
+	app.Config.InfluxDB.setDefaults(time.Hour)
+	writer := newInfluxDBWriter(app)
+	before := time.Now().UnixNano()
+	writer.send(context.Background())
+	after := time.Now().UnixNano()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(requests))
+	}
+	if got := requests[0].Header.Get("Authorization"); got != "Token secret" {
+		t.Errorf("Authorization %q, want Token secret", got)
+	}
+	if got := requests[0].URL.Query().Get("db"); got != "metrics" {
+		t.Errorf("db %q, want metrics", got)
+	}
+	var found []string
+	for _, line := range strings.Split(strings.TrimSuffix(bodies[0], "\n"), "\n") {
+		if strings.HasPrefix(line, `log\ errors_total,`) {
+			found = strings.Split(line, " value=")
+		}
+	}
+	if len(found) != 2 {
+		t.Fatalf("log errors_total was not sent: %q", bodies[0])
+	}
+	if want := `log\ errors_total,application=myapp,environment=prod,pod=api\=1,team=pay\ ments\,eu,site=edge\ 01`; found[0] != want {
+		t.Errorf("series %s, want %s", found[0], want)
+	}
+	fields := strings.Fields(found[1])
+	if len(fields) != 2 || fields[0] != "3" {
+		t.Fatalf("fields %q, want a value of 3 and a timestamp", found[1])
+	}
+	if timestamp, _ := strconv.ParseInt(fields[1], 10, 64); timestamp < before || timestamp > after {
+		t.Errorf("timestamp %s, want between %d and %d", fields[1], before, after)
+	}
+	if got := counterValue(t, writer.pointsSent); got != float64(strings.Count(bodies[0], "\n")) {
+		t.Errorf("%g points sent, want %d", got, strings.Count(bodies[0], "\n"))
+	}
+}
+
+func TestInfluxDBStopDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	app, err := New(Config{IngestTokens: []string{"secret"}, InfluxDB: InfluxDBConfig{URL: server.URL, Interval: time.Hour}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	application := app.AddApplication(0, "myapp", IngestPath, 1000, false)
+	metrics.GetOrRegisterCounter("errors-total", application.MetricsRegistry).Inc(3)
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	app.Stop(ctx)
+
+	// the final write to the hanging endpoint gives up at the deadline
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the final influxdb write outlived the Stop deadline")
+	}
+}
@@ -0,0 +1,132 @@
+package prometheuslog
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// IngestPath is the log path of an application whose lines are posted to
+// IngestHandler instead of read from a file.
+const IngestPath = "http:"
+
+const (
+	maxIngestBodySize = 10 * 1024 * 1024
+	ingestQueueSize   = 1024
+)
+
+// ingestInput is the Input of an IngestPath application. Its lines are
+// never closed; it ends with the App.
+type ingestInput struct {
+	lines chan Line
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newIngestInput() *ingestInput {
+	return &ingestInput{lines: make(chan Line, ingestQueueSize), done: make(chan struct{})}
+}
+
+func (input *ingestInput) Lines() <-chan Line { return input.lines }
+func (input *ingestInput) Err() error         { return nil }
+func (input *ingestInput) Close()             { input.once.Do(func() { close(input.done) }) }
+
+// IngestHandler returns an http.Handler for POST /ingest/<application>,
+// which queues the posted lines for an IngestPath application. The body is
+// newline delimited text, or a JSON array of strings when the Content-Type
+// is application/json. Requests must send one of Config.IngestTokens as
+// "Authorization: Bearer <token>"; every request is refused when there are
+// none.
+func (app *App) IngestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !app.ingestAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="prometheuslog"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		applicationName := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		input := app.ingestInput(applicationName)
+		if input == nil {
+			http.Error(w, fmt.Sprintf("no application %q with log path %s", applicationName, IngestPath), http.StatusNotFound)
+			return
+		}
+		lines, err := readIngestBody(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, line := range lines {
+			select {
+			case input.lines <- Line{Text: line}:
+			case <-input.done:
+				http.Error(w, "application stopped", http.StatusServiceUnavailable)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Lines int `json:"lines"`
+		}{len(lines)})
+	})
+}
+
+func (app *App) ingestAuthorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	for _, allowed := range app.Config.IngestTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// ingestInput returns the input of a started IngestPath application.
+func (app *App) ingestInput(applicationName string) *ingestInput {
+	app.Lock()
+	defer app.Unlock()
+	for _, application := range app.Applications {
+		if application.ApplicationName == applicationName {
+			return application.ingest
+		}
+	}
+	return nil
+}
+
+// readIngestBody reads every line of the body before any is queued, so a
+// malformed request queues nothing.
+func readIngestBody(w http.ResponseWriter, r *http.Request) ([]string, error) {
+	body := http.MaxBytesReader(w, r.Body, maxIngestBodySize)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var lines []string
+		if err := json.NewDecoder(body).Decode(&lines); err != nil {
+			return nil, fmt.Errorf("expected a JSON array of strings: %v", err)
+		}
+		return lines, nil
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSuffix(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
@@ -0,0 +1,173 @@
+package prometheuslog
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ingestApp starts an App with the ingest applications builds and
+// containers, whose lines are in the docker format, and a file application.
+func ingestApp(t *testing.T) (*App, http.Handler) {
+	t.Helper()
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(logPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	app, err := New(Config{IngestTokens: []string{"secret", "other"}, Watch: WatchPoll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.AddApplication(0, "builds", IngestPath, 1000, false)
+	app.AddApplication(1, "containers", IngestPath, 1000, false).Format = FormatDocker
+	app.AddApplication(2, "file", logPath, 1000, false)
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.Stop(context.Background()) })
+	return app, app.IngestHandler()
+}
+
+func ingest(handler http.Handler, applicationName string, contentType string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/ingest/"+applicationName, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// waitForLevels waits until an application has counted want by level, and
+// fails if it counts more.
+func waitForLevels(t *testing.T, application *Application, want map[string]float64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		counts := map[string]float64{}
+		application.levelValues(func(name string, level string, value float64) {
+			if value > 0 {
+				counts[level] = value
+			}
+		})
+		if maps.Equal(counts, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s counted %v, want %v", application.ApplicationName, counts, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIngestAuthorization(t *testing.T) {
+	_, handler := ingestApp(t)
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		status        int
+	}{
+		{name: "token", method: http.MethodPost, authorization: "Bearer secret", status: http.StatusOK},
+		{name: "another token", method: http.MethodPost, authorization: "Bearer other", status: http.StatusOK},
+		{name: "no token", method: http.MethodPost, status: http.StatusUnauthorized},
+		{name: "empty token", method: http.MethodPost, authorization: "Bearer ", status: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodPost, authorization: "Bearer secrets", status: http.StatusUnauthorized},
+		{name: "basic", method: http.MethodPost, authorization: "Basic c2VjcmV0", status: http.StatusUnauthorized},
+		{name: "get", method: http.MethodGet, authorization: "Bearer secret", status: http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/ingest/builds", strings.NewReader("INFO done\n"))
+			if test.authorization != "" {
+				r.Header.Set("Authorization", test.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if test.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+		})
+	}
+
+	// without tokens, every request is refused
+	app := NewApp()
+	r := httptest.NewRequest(http.MethodPost, "/ingest/builds", strings.NewReader("INFO done\n"))
+	r.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	app.IngestHandler().ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d without tokens, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestIngestRouting(t *testing.T) {
+	app, handler := ingestApp(t)
+
+	w := ingest(handler, "builds", "", "ERROR step failed\r\nINFO done\n")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"lines":2}` {
+		t.Fatalf("status %d %s, want 200 with 2 lines", w.Code, w.Body)
+	}
+	w = ingest(handler, "builds", "application/json", `["WARN slow step"]`)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"lines":1}` {
+		t.Fatalf("status %d %s, want 200 with 1 line", w.Code, w.Body)
+	}
+	waitForLevels(t, app.GetApplication(0), map[string]float64{"error": 1, "info": 1, "warn": 1})
+	waitForLevels(t, app.GetApplication(1), map[string]float64{})
+
+	for _, applicationName := range []string{"unknown", "file"} {
+		if w := ingest(handler, applicationName, "", "ERROR step failed\n"); w.Code != http.StatusNotFound {
+			t.Errorf("status %d for %s, want %d", w.Code, applicationName, http.StatusNotFound)
+		}
+	}
+}
+
+func TestIngestBodyLimits(t *testing.T) {
+	app, handler := ingestApp(t)
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{name: "body too large", body: strings.Repeat("ERROR step failed\n", maxIngestBodySize/18+1)},
+		{name: "line too long", body: "ERROR step failed\n" + strings.Repeat("x", maxLineLength+1) + "\n"},
+		{name: "malformed json", contentType: "application/json", body: `["ERROR step failed"`},
+		{name: "json not strings", contentType: "application/json", body: `["ERROR step failed", 1]`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if w := ingest(handler, "builds", test.contentType, test.body); w.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+
+	// nothing of a refused request is queued, so only this line is counted
+	if w := ingest(handler, "builds", "", "INFO done\n"); w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	waitForLevels(t, app.GetApplication(0), map[string]float64{"info": 1})
+}
+
+func TestIngestFormat(t *testing.T) {
+	app, handler := ingestApp(t)
+	body := `{"log":"ERROR step failed\n","stream":"stderr","time":"2026-02-28T10:00:00.000000001Z"}
+{"log":"WARN a long ","stream":"stdout","time":"2026-02-28T10:00:01Z"}
+{"log":"line\n","stream":"stdout","time":"2026-02-28T10:00:01Z"}
+`
+	if w := ingest(handler, "containers", "", body); w.Code != http.StatusOK {
+		t.Fatalf("status %d %s, want 200", w.Code, w.Body)
+	}
+	// the records are unwrapped and the split line joined before counting
+	waitForLevels(t, app.GetApplication(1), map[string]float64{"error": 1, "warn": 1})
+}
@@ -0,0 +1,224 @@
+package prometheuslog
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// StdinPath is the log path of an application that reads standard input,
+// e.g. kubectl logs -f mypod | prometheuslog --stdin mypod.
+const StdinPath = "-"
+
+// Input is a source of log lines for an application.
+type Input interface {
+	// Lines delivers each line. It is closed when the input ends; Err then
+	// reports why, or nil at the end of the input.
+	Lines() <-chan Line
+	Err() error
+	Close()
+}
+
+// Line is a log line read from an Input.
+type Line struct {
+	Text   string    // without the trailing newline
+	Fields Fields    // parsed by the input, e.g. the syslog severity; may be nil
+	Time   time.Time // when the input knows it; zero otherwise
+}
+
+// openInput opens the input of an application: messages routed by the
+// syslog receiver for a SyslogPathPrefix path, lines posted to
+// IngestHandler for IngestPath, standard input for StdinPath, a reader that
+// reopens the pipe after each writer for a named pipe, and a fileInput that
+// survives rotation and truncation for anything else. Files are read from
+// the end unless the application was discovered after Start, and watched
+// as chosen by watchFile.
+func (application *Application) openInput() (Input, error) {
+	logPath := application.LogPath
+	if strings.HasPrefix(logPath, SyslogPathPrefix) {
+		if application.syslog == nil {
+			return nil, errors.New("no syslog listener is configured")
+		}
+		return application.syslog.addRoute(logPath)
+	}
+	if logPath == IngestPath {
+		return newIngestInput(), nil
+	}
+	if logPath == StdinPath {
+		return newReaderInput(func() (io.ReadCloser, error) { return os.Stdin, nil }, false), nil
+	}
+	if isNamedPipe(logPath) {
+		return newPipeInput(logPath), nil
+	}
+	watch, err := application.watchFile(logPath)
+	if err != nil {
+		return nil, err
+	}
+	input, err := newFileInput(logPath, application.fromStart, watch, application.logger,
+		application.files.rotations.WithLabelValues(application.ApplicationName),
+		application.files.truncations.WithLabelValues(application.ApplicationName))
+	if err != nil {
+		watch.stop()
+		return nil, err
+	}
+	return input, nil
+}
+
+func isNamedPipe(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// readerInput reads lines from a stream such as standard input or a named
+// pipe. With reopen, the stream is opened again at its end: a named pipe
+// ends each time its writer closes it, and opening it again waits for the
+// next writer.
+type readerInput struct {
+	lines   chan Line
+	done    chan struct{}
+	once    sync.Once
+	unblock func() // ends an open that is waiting, called by Close
+
+	mu     sync.Mutex
+	reader io.ReadCloser
+	err    error
+}
+
+func newReaderInput(open func() (io.ReadCloser, error), reopen bool) *readerInput {
+	input := &readerInput{
+		lines: make(chan Line),
+		done:  make(chan struct{}),
+	}
+	go input.read(open, reopen)
+	return input
+}
+
+// newPipeInput reads a named pipe, opening it again after each writer.
+// Opening a named pipe waits for a writer, so Close opens it for writing
+// without blocking, which completes a waiting open.
+func newPipeInput(path string) *readerInput {
+	input := &readerInput{
+		lines: make(chan Line),
+		done:  make(chan struct{}),
+		unblock: func() {
+			if pipe, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+				pipe.Close()
+			}
+		},
+	}
+	go input.read(func() (io.ReadCloser, error) { return os.Open(path) }, true)
+	return input
+}
+
+func (input *readerInput) read(open func() (io.ReadCloser, error), reopen bool) {
+	defer close(input.lines)
+	for {
+		reader, closed, err := input.open(open)
+		if closed {
+			return
+		}
+		if err != nil {
+			input.setErr(err)
+			return
+		}
+		if !input.setReader(reader) {
+			reader.Close()
+			return
+		}
+
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+		for scanner.Scan() {
+			select {
+			case input.lines <- Line{Text: scanner.Text()}:
+			case <-input.done:
+				return
+			}
+		}
+		reader.Close()
+		if err := scanner.Err(); err != nil {
+			select {
+			case <-input.done:
+			default:
+				input.setErr(err)
+			}
+			return
+		}
+		if !reopen {
+			return
+		}
+	}
+}
+
+// open calls open in a goroutine, which is abandoned if the input is closed
+// first, and closes the stream if it opens after that. closed reports that
+// the input was closed.
+func (input *readerInput) open(open func() (io.ReadCloser, error)) (reader io.ReadCloser, closed bool, err error) {
+	type opened struct {
+		reader io.ReadCloser
+		err    error
+	}
+	result := make(chan opened, 1)
+	go func() {
+		reader, err := open()
+		result <- opened{reader, err}
+	}()
+	select {
+	case opened := <-result:
+		return opened.reader, false, opened.err
+	case <-input.done:
+		go func() {
+			if opened := <-result; opened.reader != nil {
+				opened.reader.Close()
+			}
+		}()
+		return nil, true, nil
+	}
+}
+
+// setReader records the current stream so Close can interrupt a read. It
+// returns false if the input is already closed.
+func (input *readerInput) setReader(reader io.ReadCloser) bool {
+	input.mu.Lock()
+	defer input.mu.Unlock()
+	select {
+	case <-input.done:
+		return false
+	default:
+	}
+	input.reader = reader
+	return true
+}
+
+func (input *readerInput) setErr(err error) {
+	input.mu.Lock()
+	defer input.mu.Unlock()
+	input.err = err
+}
+
+func (input *readerInput) Lines() <-chan Line { return input.lines }
+
+func (input *readerInput) Err() error {
+	input.mu.Lock()
+	defer input.mu.Unlock()
+	return input.err
+}
+
+func (input *readerInput) Close() {
+	input.once.Do(func() {
+		input.mu.Lock()
+		close(input.done)
+		if input.reader != nil {
+			input.reader.Close()
+		}
+		input.mu.Unlock()
+		if input.unblock != nil {
+			input.unblock()
+		}
+	})
+}
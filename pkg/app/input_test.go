@@ -0,0 +1,52 @@
+//go:build !windows
+
+package prometheuslog
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPipeInput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.pipe")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skip(err)
+	}
+	input := newPipeInput(path)
+
+	// each writer's lines are read, and the pipe is opened again after it
+	for _, text := range []string{"first", "second"} {
+		writer, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.WriteString(text + "\n")
+		writer.Close()
+		select {
+		case line := <-input.Lines():
+			if line.Text != text {
+				t.Errorf("read %q, want %q", line.Text, text)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q was not read", text)
+		}
+	}
+
+	// with no writer, the input waits in open, which Close must end
+	time.Sleep(50 * time.Millisecond)
+	input.Close()
+	select {
+	case _, ok := <-input.Lines():
+		if ok {
+			t.Error("read a line after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not end the input")
+	}
+	if err := input.Err(); err != nil {
+		t.Error(err)
+	}
+}
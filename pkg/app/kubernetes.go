@@ -0,0 +1,213 @@
+package prometheuslog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultKubernetesApplication = "kubernetes"
+	defaultKubernetesInterval    = 10 * time.Second
+)
+
+// KubernetesConfig configures discovering the container logs of a
+// Kubernetes node, in LogDirectory/<namespace>_<pod>_<uid>/<container>/<n>.log.
+// Each container becomes an application named <namespace>/<pod>/<container>
+// whose metrics are exported as <Application>_<environment>_<metric> with
+// the labels namespace, pod and container, which are also fields of every
+// line for rule selectors. Discovery is disabled when LogDirectory is empty.
+type KubernetesConfig struct {
+	LogDirectory string        // usually /var/log/pods
+	Application  string        // used in metric names, default kubernetes
+	Format       string        // default cri
+	Interval     time.Duration // how often to look for new and removed containers, default 10s
+}
+
+func (config *KubernetesConfig) setDefaults() {
+	if config.Application == "" {
+		config.Application = defaultKubernetesApplication
+	}
+	if config.Format == "" {
+		config.Format = FormatCRI
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultKubernetesInterval
+	}
+}
+
+func (config *KubernetesConfig) check() error {
+	return checkFormat(config.Format)
+}
+
+// discoveryWorker follows the containers found when it starts, then looks
+// for new and removed containers every interval.
+func (app *App) discoveryWorker(ctx context.Context) {
+	defer app.wg.Done()
+
+	app.discoverPods(ctx, false)
+	ticker := time.NewTicker(app.Config.Kubernetes.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			app.discoverPods(ctx, true)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// podContainer is a container log found in the pod log directory.
+type podContainer struct {
+	labels  map[string]string
+	logPath string
+}
+
+// discoverPods starts an application for every new container and removes
+// the application of every container whose directory disappeared. When a
+// container restarts it logs to a new file, and its application is
+// replaced. Logs found after Start are read from the beginning. New
+// applications are prepared with the App lock held, then started without
+// it, so lines keep being processed meanwhile, and added to
+// App.Applications once following.
+func (app *App) discoverPods(ctx context.Context, fromStart bool) {
+	config := app.Config.Kubernetes
+	found, err := findPodContainers(config.LogDirectory)
+	if err != nil {
+		app.Config.Logger.Error("unable to read pod log directory", "directory", config.LogDirectory, "error", err)
+		return
+	}
+
+	app.Lock()
+	if app.discovered == nil {
+		app.discovered = map[string]*Application{}
+	}
+	for directory, application := range app.discovered {
+		if container, ok := found[directory]; ok && container.logPath == application.LogPath {
+			continue
+		}
+		application.logger.Info("container log removed", "log", application.LogPath)
+		app.removeApplication(application)
+		delete(app.discovered, directory)
+	}
+	added := map[string]*Application{}
+	for directory, container := range found {
+		if app.discovered[directory] != nil {
+			continue
+		}
+		name := strings.Join([]string{container.labels["namespace"], container.labels["pod"], container.labels["container"]}, "/")
+		application := NewApplication(app, app.nextID, name)
+		app.nextID++
+		application.LogPath = container.logPath
+		application.Format = config.Format
+		application.Labels = container.labels
+		application.ReadRate = app.Config.MaxIngestionRate
+		application.DebugEnabled = app.Config.Debug
+		application.MetricsRegistry = application.createRegistry(name)
+		application.metricsName = config.Application
+		application.fromStart = fromStart
+		application.prepare()
+		added[directory] = application
+	}
+	app.Unlock()
+
+	for directory, application := range added {
+		applicationCtx, cancel := context.WithCancel(ctx)
+		application.cancel = cancel
+		if err := app.startApplication(applicationCtx, application); err != nil {
+			cancel()
+			application.logger.Error("unable to follow container log", "log", application.LogPath, "error", err)
+			delete(added, directory)
+		}
+	}
+
+	app.Lock()
+	defer app.Unlock()
+	for directory, application := range added {
+		if ctx.Err() != nil {
+			app.removeApplication(application)
+			continue
+		}
+		app.Applications = append(app.Applications, application)
+		app.discovered[directory] = application
+	}
+}
+
+// removeApplication stops a discovered application and removes its
+// metrics. The caller must hold the App lock.
+func (app *App) removeApplication(application *Application) {
+	application.cancel()
+	app.files.delete(application.ApplicationName)
+	app.collector.Unregister(application.levelCollector)
+	if application.labeledCollector != nil {
+		app.collector.Unregister(application.labeledCollector)
+	}
+	applications := make([]*Application, 0, len(app.Applications))
+	for _, existing := range app.Applications {
+		if existing != application {
+			applications = append(applications, existing)
+		}
+	}
+	app.Applications = applications
+}
+
+// findPodContainers returns the current log of every container in a pod log
+// directory, by container directory.
+func findPodContainers(logDirectory string) (map[string]podContainer, error) {
+	pods, err := os.ReadDir(logDirectory)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]podContainer{}
+	for _, pod := range pods {
+		// namespace and pod names can't contain underscores
+		parts := strings.SplitN(pod.Name(), "_", 3)
+		if !pod.IsDir() || len(parts) != 3 {
+			continue
+		}
+		podDirectory := filepath.Join(logDirectory, pod.Name())
+		containers, err := os.ReadDir(podDirectory)
+		if err != nil {
+			continue
+		}
+		for _, container := range containers {
+			if !container.IsDir() {
+				continue
+			}
+			directory := filepath.Join(podDirectory, container.Name())
+			logPath := currentContainerLog(directory)
+			if logPath == "" {
+				continue
+			}
+			found[directory] = podContainer{
+				labels:  map[string]string{"namespace": parts[0], "pod": parts[1], "container": container.Name()},
+				logPath: logPath,
+			}
+		}
+	}
+	return found, nil
+}
+
+// currentContainerLog returns the log with the highest restart count in a
+// container directory, ignoring rotated logs, or "" if there is none.
+func currentContainerLog(directory string) string {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return ""
+	}
+	current, restarts := "", -1
+	for _, entry := range entries {
+		n, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".log"))
+		if err != nil || !strings.HasSuffix(entry.Name(), ".log") || entry.IsDir() {
+			continue
+		}
+		if n > restarts {
+			current, restarts = filepath.Join(directory, entry.Name()), n
+		}
+	}
+	return current
+}
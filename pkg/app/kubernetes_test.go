@@ -0,0 +1,138 @@
+package prometheuslog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeContainerLog appends text to a container log in a pod log directory.
+func writeContainerLog(t *testing.T, logDirectory string, pod string, name string, text string) {
+	t.Helper()
+	directory := filepath.Join(logDirectory, "payments_"+pod+"_0123", "api")
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(filepath.Join(directory, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// discoveredPod returns the application of a pod's container, or nil.
+func discoveredPod(app *App, pod string) *Application {
+	app.Lock()
+	defer app.Unlock()
+	for _, application := range app.Applications {
+		if application.Labels["pod"] == pod {
+			return application
+		}
+	}
+	return nil
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKubernetesDiscovery(t *testing.T) {
+	logDirectory := t.TempDir()
+	writeContainerLog(t, logDirectory, "api-a", "0.log", "")
+
+	app, err := New(Config{
+		Kubernetes:   KubernetesConfig{LogDirectory: logDirectory, Format: FormatDocker, Interval: 20 * time.Millisecond},
+		Watch:        WatchPoll,
+		PollInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the handlers are resolved for discovered applications too
+	var mu sync.Mutex
+	handled := map[string]bool{}
+	app.AddLineHandler(AllApplications, LineHandlerFunc(func(line string, fields Fields, timestamp time.Time, sink MetricsSink) {
+		mu.Lock()
+		defer mu.Unlock()
+		handled[fields["pod"]+": "+line] = true
+	}))
+	wasHandled := func(line string) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return handled[line]
+		}
+	}
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Stop(context.Background())
+
+	waitFor(t, "api-a to be discovered", func() bool { return discoveredPod(app, "api-a") != nil })
+	first := discoveredPod(app, "api-a")
+	if first.ApplicationName != "payments/api-a/api" || first.exportedName() != defaultKubernetesApplication {
+		t.Errorf("application %s exported as %s, want payments/api-a/api exported as %s", first.ApplicationName, first.exportedName(), defaultKubernetesApplication)
+	}
+	writeContainerLog(t, logDirectory, "api-a", "0.log", `{"log":"INFO started\n","stream":"stdout","time":"2026-02-28T10:00:00Z"}`+"\n")
+	waitFor(t, "api-a's line", wasHandled("api-a: INFO started"))
+
+	// a new pod's log is read from its beginning
+	writeContainerLog(t, logDirectory, "api-b", "0.log", `{"log":"ERROR failed\n","stream":"stderr","time":"2026-02-28T10:00:01Z"}`+"\n")
+	waitFor(t, "api-b's line", wasHandled("api-b: ERROR failed"))
+	waitFor(t, "api-b to be discovered", func() bool { return discoveredPod(app, "api-b") != nil })
+	second := discoveredPod(app, "api-b")
+	if second.ID <= first.ID {
+		t.Errorf("api-b has the ID %d, want more than api-a's %d", second.ID, first.ID)
+	}
+
+	// a vanished pod's application is stopped and its metrics removed
+	if err := os.RemoveAll(filepath.Join(logDirectory, "payments_api-a_0123")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "api-a to be removed", func() bool { return discoveredPod(app, "api-a") == nil })
+	families, err := app.gatherer().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "pod" && label.GetValue() == "api-a" {
+					t.Errorf("%s is still exported for api-a", family.GetName())
+				}
+			}
+		}
+	}
+
+	// a restarted container is followed in its new log, as a new application
+	writeContainerLog(t, logDirectory, "api-b", "1.log", `{"log":"INFO restarted\n","stream":"stdout","time":"2026-02-28T10:00:02Z"}`+"\n")
+	waitFor(t, "api-b's new line", wasHandled("api-b: INFO restarted"))
+	waitFor(t, "api-b to be replaced", func() bool {
+		third := discoveredPod(app, "api-b")
+		return third != nil && third != second
+	})
+	third := discoveredPod(app, "api-b")
+	if third.ID <= second.ID {
+		t.Errorf("restarted api-b has the ID %d, want more than %d", third.ID, second.ID)
+	}
+
+	// the pod that vanished first doesn't get its ID back when it returns
+	writeContainerLog(t, logDirectory, "api-a", "0.log", "")
+	waitFor(t, "api-a to be discovered again", func() bool { return discoveredPod(app, "api-a") != nil })
+	if fourth := discoveredPod(app, "api-a"); fourth.ID <= third.ID {
+		t.Errorf("rediscovered api-a has the ID %d, want more than %d", fourth.ID, third.ID)
+	}
+}
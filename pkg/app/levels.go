@@ -0,0 +1,269 @@
+package prometheuslog
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// logLevels are the levels counted in log_messages_total, from least to
+// most severe.
+var logLevels = [...]string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// defaultLevelAliases are the spellings of each level, lowercased,
+// including the syslog severities.
+var defaultLevelAliases = map[string]string{
+	"trace": "trace", "trc": "trace", "finest": "trace", "finer": "trace",
+	"debug": "debug", "dbg": "debug", "fine": "debug",
+	"info": "info", "inf": "info", "information": "info", "informational": "info", "notice": "info",
+	"warn": "warn", "warning": "warn", "wrn": "warn",
+	"error": "error", "err": "error", "eror": "error",
+	"fatal": "fatal", "ftl": "fatal", "critical": "fatal", "crit": "fatal", "severe": "fatal",
+	"panic": "fatal", "alert": "fatal", "emerg": "fatal", "emergency": "fatal",
+}
+
+// levelWords is how many words at the start of a line are searched for a
+// level when neither a field nor a regex is configured.
+const levelWords = 5
+
+// levelJSONField finds the level field of a JSON log line.
+var levelJSONField = regexp.MustCompile(`"(?i:level|lvl|severity)"\s*:\s*"([^"]*)"`)
+
+// LevelConfig configures how the level of a log line is found, for the
+// log_messages_total{level} counter and the "level" field of rules. A
+// "level" or "severity" field from the input, e.g. syslog, is used first.
+// Otherwise, the level is the Field'th word of the line, or the "level"
+// (or first) group of Regex, or by default found where logs put it: the
+// level field of a JSON line, a level=info pair, a bracketed [warn], or a
+// word such as ERROR starting the line after its timestamp. A level word
+// elsewhere, as in "User updated alert settings", is not a level.
+type LevelConfig struct {
+	Field   int               // 1-based whitespace separated word holding the level
+	Regex   string            // e.g. "level":"(\w+)" for JSON logs
+	Aliases map[string]string // more spellings of a level, e.g. {"E": "error", "severe": "fatal"}
+}
+
+// levelFor returns the level config of an application: its Field or Regex
+// when either is set, and otherwise those of config, with the aliases of
+// both.
+func (config LevelConfig) levelFor(application LevelConfig) LevelConfig {
+	merged := config
+	if application.Field != 0 || application.Regex != "" {
+		merged.Field, merged.Regex = application.Field, application.Regex
+	}
+	merged.Aliases = map[string]string{}
+	for alias, level := range config.Aliases {
+		merged.Aliases[alias] = level
+	}
+	for alias, level := range application.Aliases {
+		merged.Aliases[alias] = level
+	}
+	return merged
+}
+
+// levelMatcher finds the level of log lines as configured by a LevelConfig.
+type levelMatcher struct {
+	field   int
+	regex   *regexp.Regexp
+	group   int
+	aliases map[string]string // by lowercased spelling
+}
+
+func (config LevelConfig) compile() (*levelMatcher, error) {
+	if config.Field < 0 {
+		return nil, fmt.Errorf("level field must be positive, got %d", config.Field)
+	}
+	if config.Field != 0 && config.Regex != "" {
+		return nil, fmt.Errorf("set either a level field or a level regex, not both")
+	}
+	matcher := &levelMatcher{field: config.Field, aliases: map[string]string{}}
+	if config.Regex != "" {
+		regex, err := regexp.Compile(config.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("level regex: %v", err)
+		}
+		if regex.NumSubexp() == 0 {
+			return nil, fmt.Errorf("level regex %q has no group for the level", config.Regex)
+		}
+		matcher.regex, matcher.group = regex, 1
+		if i := regex.SubexpIndex("level"); i > 0 {
+			matcher.group = i
+		}
+	}
+	for alias, level := range defaultLevelAliases {
+		matcher.aliases[alias] = level
+	}
+	for alias, level := range config.Aliases {
+		level = strings.ToLower(level)
+		if levelIndex(level) < 0 {
+			return nil, fmt.Errorf("level alias %q: unknown level %q, expected one of %s", alias, level, strings.Join(logLevels[:], ", "))
+		}
+		matcher.aliases[strings.ToLower(alias)] = level
+	}
+	return matcher, nil
+}
+
+// match returns the level of a line, if it has one.
+func (matcher *levelMatcher) match(line string, fields Fields) (string, bool) {
+	for _, name := range []string{"level", "severity"} {
+		if value, ok := fields[name]; ok {
+			if level, ok := matcher.lookup(value); ok {
+				return level, true
+			}
+		}
+	}
+	switch {
+	case matcher.regex != nil:
+		submatch := matcher.regex.FindStringSubmatch(line)
+		if submatch == nil {
+			return "", false
+		}
+		return matcher.lookup(submatch[matcher.group])
+	case matcher.field > 0:
+		words := strings.Fields(line)
+		if len(words) < matcher.field {
+			return "", false
+		}
+		return matcher.lookup(words[matcher.field-1])
+	}
+	return matcher.matchPosition(line)
+}
+
+// matchPosition finds the level of a line without a configured position.
+// In a JSON line, it is the level, lvl or severity field. Otherwise it is
+// the value of a level=, lvl= or severity= pair anywhere in the line, or in
+// the first five words, a bracketed level such as [warn], or the first
+// word that isn't a timestamp, a bracketed word such as a thread name, or
+// a key=value pair.
+func (matcher *levelMatcher) matchPosition(line string) (string, bool) {
+	if strings.HasPrefix(strings.TrimLeft(line, " \t"), "{") {
+		if submatch := levelJSONField.FindStringSubmatch(line); submatch != nil {
+			return matcher.lookup(submatch[1])
+		}
+		return "", false
+	}
+
+	words := strings.Fields(line)
+	for _, word := range words {
+		if key, _, ok := strings.Cut(word, "="); ok && isLevelKey(key) {
+			if level, ok := matcher.lookup(word); ok {
+				return level, true
+			}
+		}
+	}
+	for _, word := range words[:min(len(words), levelWords)] {
+		switch {
+		case strings.ContainsAny(word[:1], "[<("):
+			if level, ok := matcher.lookup(word); ok {
+				return level, true
+			}
+		case strings.Contains(word, "="), word[0] >= '0' && word[0] <= '9':
+		default:
+			return matcher.lookup(word)
+		}
+	}
+	return "", false
+}
+
+func isLevelKey(key string) bool {
+	switch strings.ToLower(key) {
+	case "level", "lvl", "severity":
+		return true
+	}
+	return false
+}
+
+// lookup returns the level spelled by word, ignoring case, surrounding
+// punctuation and a level=, lvl= or severity= prefix.
+func (matcher *levelMatcher) lookup(word string) (string, bool) {
+	word = strings.Trim(word, "[]()<>{}:;,|\"'")
+	if key, value, ok := strings.Cut(word, "="); ok {
+		if !isLevelKey(key) {
+			return "", false
+		}
+		word = strings.Trim(value, "\"'")
+	}
+	level, ok := matcher.aliases[word]
+	if !ok {
+		level, ok = matcher.aliases[strings.ToLower(word)]
+	}
+	return level, ok
+}
+
+func levelIndex(level string) int {
+	for i, known := range logLevels {
+		if known == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// levelCounts counts the lines of an application by level.
+type levelCounts [len(logLevels)]int64
+
+func (counts *levelCounts) inc(level string) {
+	atomic.AddInt64(&counts[levelIndex(level)], 1)
+}
+
+// each calls fn with every level that has been seen and its count.
+func (counts *levelCounts) each(fn func(level string, count int64)) {
+	for i, level := range logLevels {
+		if count := atomic.LoadInt64(&counts[i]); count > 0 {
+			fn(level, count)
+		}
+	}
+}
+
+// levelMetric is the registry style name of the level counts, which every
+// sink names like the metrics of an application's registry.
+const levelMetric = "log-messages-total"
+
+// levelValues calls fn with the name, level and count of every level an
+// application has seen. The level counts aren't in MetricsRegistry, so
+// every sink also reads them through levelValues.
+func (application *Application) levelValues(fn func(name string, level string, value float64)) {
+	application.levelCounts.each(func(level string, count int64) {
+		fn(levelMetric, level, float64(count))
+	})
+}
+
+// exposedLevelValues calls fn with the exposed name, including the level
+// label, and count of every level an application has seen.
+func (application *Application) exposedLevelValues(applicationName string, fn func(name string, value float64)) {
+	application.levelValues(func(name string, level string, value float64) {
+		fn(fmt.Sprintf("%s{level=%q}", metricName(applicationName, application.Config.Environment, name), level), value)
+	})
+}
+
+// levelCollector exports the level counts of an application as
+// <application>_<environment>_log_messages_total{level}, with the
+// application's labels. Every level is exported, so rates start at zero.
+type levelCollector struct {
+	counts      *levelCounts
+	desc        *prometheus.Desc
+	labelValues []string
+}
+
+func newLevelCollector(counts *levelCounts, name string, environment string, labels map[string]string) *levelCollector {
+	labelNames, labelValues := sortedLabels(labels)
+	return &levelCollector{
+		counts:      counts,
+		desc:        prometheus.NewDesc(metricName(name, environment, levelMetric), "Log lines by level.", append(labelNames, "level"), nil),
+		labelValues: labelValues,
+	}
+}
+
+// Describe sends nothing, so discovered applications sharing a metric
+// name can be registered separately.
+func (c *levelCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *levelCollector) Collect(ch chan<- prometheus.Metric) {
+	for i, level := range logLevels {
+		labelValues := append(append([]string{}, c.labelValues...), level)
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(atomic.LoadInt64(&c.counts[i])), labelValues...)
+	}
+}
@@ -0,0 +1,79 @@
+package prometheuslog
+
+import "testing"
+
+func TestLevelMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		config LevelConfig
+		line   string
+		fields Fields
+		level  string // "" when the line has none
+	}{
+		// plain
+		{name: "first word", line: "ERROR payment failed", level: "error"},
+		{name: "first word with a colon", line: "Warning: disk almost full", level: "warn"},
+		{name: "after a timestamp", line: "2026-02-28 10:00:05,123 INFO started", level: "info"},
+		{name: "after an rfc 3339 timestamp", line: "2026-02-28T10:00:05Z crit disk failed", level: "fatal"},
+		{name: "after a thread", line: "2026-02-28 10:00:05,123 [main] DEBUG loading config", level: "debug"},
+		{name: "message text", line: "User updated alert settings", level: ""},
+		{name: "message text after a timestamp", line: "2026-02-28 10:00:05 User updated alert settings", level: ""},
+		{name: "message text after the first word", line: "myapp: critical section entered", level: ""},
+		{name: "empty", line: "", level: ""},
+
+		// bracketed
+		{name: "bracketed", line: "[warn] slow request", level: "warn"},
+		{name: "bracketed after a timestamp", line: "[2026-02-28 10:00:05] [ERROR] payment failed", level: "error"},
+		{name: "angle bracketed", line: "2026-02-28 10:00:05 <fatal> out of memory", level: "fatal"},
+		{name: "bracketed in the message", line: "Started worker pool for [alert] handling", level: ""},
+		{name: "bracketed past five words", line: "2026-02-28 10:00:05 [main] [worker-1] [pool] [panic] recovered", level: ""},
+
+		// logfmt
+		{name: "logfmt", line: `ts=2026-02-28T10:00:05Z level=error msg="payment failed"`, level: "error"},
+		{name: "logfmt at the end", line: `ts=2026-02-28T10:00:05Z msg=done caller=main.go:12 duration=5s lvl=info`, level: "info"},
+		{name: "logfmt quoted", line: `time=10:00:05 severity="WARNING" msg=slow`, level: "warn"},
+		{name: "logfmt without a level", line: `ts=2026-02-28T10:00:05Z msg="alert sent" user=admin`, level: ""},
+		{name: "logfmt level in a value", line: `ts=2026-02-28T10:00:05Z msg=notice user=alert`, level: ""},
+
+		// json
+		{name: "json", line: `{"time":"2026-02-28T10:00:05Z","level":"error","msg":"payment failed"}`, level: "error"},
+		{name: "json severity", line: `{"severity": "WARNING", "message": "slow"}`, level: "warn"},
+		{name: "json uppercase key", line: `  {"Level":"Info","msg":"started"}`, level: "info"},
+		{name: "json without a level", line: `{"msg":"User updated alert settings","user":"admin"}`, level: ""},
+		{name: "json level word in the message", line: `{"msg":"critical path","component":"error-budget"}`, level: ""},
+
+		// configured
+		{name: "input field", line: "User updated alert settings", fields: Fields{"severity": "err"}, level: "error"},
+		{name: "input field first", line: "ERROR payment failed", fields: Fields{"level": "info"}, level: "info"},
+		{name: "field", config: LevelConfig{Field: 3}, line: "10:00:05 main WARN slow", level: "warn"},
+		{name: "field elsewhere", config: LevelConfig{Field: 3}, line: "ERROR main slow", level: ""},
+		{name: "regex", config: LevelConfig{Regex: `lv:(\w+)`}, line: "lv:E oops", level: ""},
+		{name: "regex and alias", config: LevelConfig{Regex: `lv:(\w+)`, Aliases: map[string]string{"E": "error"}}, line: "lv:E oops", level: "error"},
+		{name: "alias", config: LevelConfig{Aliases: map[string]string{"severe": "warn", "W": "warn"}}, line: "W disk almost full", level: "warn"},
+		{name: "alias overriding a default", config: LevelConfig{Aliases: map[string]string{"severe": "warn"}}, line: "[SEVERE] disk almost full", level: "warn"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matcher, err := test.config.compile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			level, ok := matcher.match(test.line, test.fields)
+			if ok != (test.level != "") || level != test.level {
+				t.Errorf("level %q (%t), want %q", level, ok, test.level)
+			}
+		})
+	}
+}
+
+func TestLevelExplain(t *testing.T) {
+	app := NewApp()
+	explanation := app.Explain("myapp", "ERROR payment failed", nil)
+	step := explanation.Steps[0]
+	if step.Name != "level" || len(step.Updates) != 1 {
+		t.Fatalf("first step %+v, want the level", step)
+	}
+	if want := `myapp_prod_log_messages_total{level="error"}`; step.Updates[0].Metric != want {
+		t.Errorf("level metric %s, want %s", step.Updates[0].Metric, want)
+	}
+}
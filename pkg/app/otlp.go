@@ -0,0 +1,254 @@
+package prometheuslog
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http"
+
+	otlpShutdownTimeout = 10 * time.Second
+)
+
+// OTLPConfig configures exporting metrics to an OpenTelemetry collector.
+// Each application is exported with the resource attributes service.name
+// (the application name) and deployment.environment. It is disabled when
+// Endpoint is empty.
+type OTLPConfig struct {
+	Endpoint string            // host:port, or a URL such as http://collector:4318
+	Protocol string            // grpc (default) or http
+	Insecure bool              // plaintext instead of TLS
+	Headers  map[string]string // sent with every export, e.g. authentication
+	Interval time.Duration     // default Config.FlushInterval
+}
+
+func (config *OTLPConfig) setDefaults(flushInterval time.Duration) {
+	if config.Protocol == "" {
+		config.Protocol = OTLPProtocolGRPC
+	}
+	if config.Interval <= 0 {
+		config.Interval = flushInterval
+	}
+}
+
+func (config *OTLPConfig) check() error {
+	if config.Protocol != OTLPProtocolGRPC && config.Protocol != OTLPProtocolHTTP {
+		return fmt.Errorf("otlp: unknown protocol %q", config.Protocol)
+	}
+	return nil
+}
+
+func (config *OTLPConfig) newExporter(ctx context.Context) (sdkmetric.Exporter, error) {
+	isURL := strings.Contains(config.Endpoint, "://")
+	if config.Protocol == OTLPProtocolHTTP {
+		options := []otlpmetrichttp.Option{otlpmetrichttp.WithHeaders(config.Headers)}
+		if isURL {
+			options = append(options, otlpmetrichttp.WithEndpointURL(config.Endpoint))
+		} else {
+			options = append(options, otlpmetrichttp.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			options = append(options, otlpmetrichttp.WithInsecure())
+		}
+		return otlpmetrichttp.New(ctx, options...)
+	}
+
+	options := []otlpmetricgrpc.Option{otlpmetricgrpc.WithHeaders(config.Headers)}
+	if isURL {
+		options = append(options, otlpmetricgrpc.WithEndpointURL(config.Endpoint))
+	} else {
+		options = append(options, otlpmetricgrpc.WithEndpoint(config.Endpoint))
+	}
+	if config.Insecure {
+		options = append(options, otlpmetricgrpc.WithInsecure())
+	}
+	return otlpmetricgrpc.New(ctx, options...)
+}
+
+// otlpWorker exports the application's metrics every interval until ctx is
+// done, then exports once more while shutting down.
+func (application *Application) otlpWorker(ctx context.Context) {
+	defer application.wg.Done()
+
+	config := application.Config.OTLP
+	exporter, err := config.newExporter(ctx)
+	if err != nil {
+		application.logger.Error("unable to create otlp exporter", "endpoint", config.Endpoint, "error", err)
+		return
+	}
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", application.ApplicationName),
+			attribute.String("deployment.environment", application.Config.Environment),
+		)),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter,
+			sdkmetric.WithInterval(config.Interval),
+			sdkmetric.WithProducer(&registryProducer{registry: application.MetricsRegistry, levels: application.levelValues, start: time.Now()}),
+		)),
+	)
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), otlpShutdownTimeout)
+	defer cancel()
+	if err := provider.Shutdown(shutdownCtx); err != nil {
+		application.logger.Error("unable to export final otlp metrics", "endpoint", config.Endpoint, "error", err)
+	}
+}
+
+// registryProducer converts a go-metrics registry to OpenTelemetry metric
+// data: counters and meters become cumulative monotonic sums, gauges become
+// gauges, and histograms and timers become exponential histograms. Names are
+// flattened the same way as for prometheus, without the application and
+// environment prefix, which are resource attributes instead. The level
+// counts become a cumulative monotonic sum with a level attribute.
+type registryProducer struct {
+	registry metrics.Registry
+	levels   func(fn func(name string, level string, value float64))
+	start    time.Time
+}
+
+const (
+	// histogramQuantiles is how many evenly spaced quantiles of a histogram
+	// or timer's sample are bucketed, each standing for an equal share of
+	// its observations.
+	histogramQuantiles = 100
+	// histogramScale is the scale of the exported exponential histograms:
+	// bucket boundaries are powers of 2^(1/4), the same in every export.
+	histogramScale = 2
+)
+
+// sampledMetric is what metrics.Histogram and metrics.Timer have in common.
+type sampledMetric interface {
+	Count() int64
+	Sum() int64
+	Min() int64
+	Max() int64
+	Percentiles([]float64) []float64
+}
+
+func (p *registryProducer) Produce(ctx context.Context) ([]metricdata.ScopeMetrics, error) {
+	now := time.Now()
+	var out []metricdata.Metrics
+	p.registry.Each(func(name string, i interface{}) {
+		m := metricdata.Metrics{Name: flattenKey(name)}
+		switch metric := i.(type) {
+		case metrics.Counter:
+			m.Data = p.sum(metric.Count(), now)
+		case metrics.Meter:
+			m.Data = p.sum(metric.Count(), now)
+		case metrics.Gauge:
+			m.Data = metricdata.Gauge[int64]{DataPoints: []metricdata.DataPoint[int64]{{StartTime: p.start, Time: now, Value: metric.Value()}}}
+		case metrics.GaugeFloat64:
+			m.Data = metricdata.Gauge[float64]{DataPoints: []metricdata.DataPoint[float64]{{StartTime: p.start, Time: now, Value: metric.Value()}}}
+		case metrics.Histogram:
+			m.Data = p.histogram(metric.Snapshot(), now)
+		case metrics.Timer:
+			m.Unit = "ns"
+			m.Data = p.histogram(metric.Snapshot(), now)
+		default:
+			return
+		}
+		out = append(out, m)
+	})
+	if p.levels != nil {
+		levels := metricdata.Sum[int64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+		p.levels(func(_ string, level string, value float64) {
+			levels.DataPoints = append(levels.DataPoints, metricdata.DataPoint[int64]{
+				Attributes: attribute.NewSet(attribute.String("level", level)),
+				StartTime:  p.start,
+				Time:       now,
+				Value:      int64(value),
+			})
+		})
+		if len(levels.DataPoints) > 0 {
+			out = append(out, metricdata.Metrics{Name: flattenKey(levelMetric), Data: levels})
+		}
+	}
+	return []metricdata.ScopeMetrics{{
+		Scope:   instrumentation.Scope{Name: "github.com/keithknott26/prometheuslog"},
+		Metrics: out,
+	}}, nil
+}
+
+func (p *registryProducer) sum(value int64, now time.Time) metricdata.Sum[int64] {
+	return metricdata.Sum[int64]{
+		Temporality: metricdata.CumulativeTemporality,
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{StartTime: p.start, Time: now, Value: value}},
+	}
+}
+
+// histogram buckets the sample of a histogram or timer. go-metrics keeps a
+// sample rather than buckets, so the observations are shared out between
+// quantiles of the sample, and the bucket counts add up to the count.
+func (p *registryProducer) histogram(sample sampledMetric, now time.Time) metricdata.ExponentialHistogram[float64] {
+	count := uint64(sample.Count())
+	point := metricdata.ExponentialHistogramDataPoint[float64]{
+		StartTime: p.start,
+		Time:      now,
+		Count:     count,
+		Sum:       float64(sample.Sum()),
+		Scale:     histogramScale,
+	}
+	if count > 0 {
+		point.Min = metricdata.NewExtrema(float64(sample.Min()))
+		point.Max = metricdata.NewExtrema(float64(sample.Max()))
+
+		quantiles := make([]float64, histogramQuantiles)
+		for i := range quantiles {
+			quantiles[i] = (float64(i) + 0.5) / histogramQuantiles
+		}
+		positive, negative := map[int32]uint64{}, map[int32]uint64{}
+		for i, value := range sample.Percentiles(quantiles) {
+			share := count*uint64(i+1)/histogramQuantiles - count*uint64(i)/histogramQuantiles
+			switch {
+			case value > 0:
+				positive[exponentialIndex(value)] += share
+			case value < 0:
+				negative[exponentialIndex(-value)] += share
+			default:
+				point.ZeroCount += share
+			}
+		}
+		point.PositiveBucket, point.NegativeBucket = exponentialBucket(positive), exponentialBucket(negative)
+	}
+	return metricdata.ExponentialHistogram[float64]{
+		Temporality: metricdata.CumulativeTemporality,
+		DataPoints:  []metricdata.ExponentialHistogramDataPoint[float64]{point},
+	}
+}
+
+// exponentialIndex returns the index of the bucket holding value, which is
+// greater than base^index and at most base^(index+1).
+func exponentialIndex(value float64) int32 {
+	return int32(math.Ceil(math.Log2(value)*(1<<histogramScale))) - 1
+}
+
+func exponentialBucket(counts map[int32]uint64) metricdata.ExponentialBucket {
+	if len(counts) == 0 {
+		return metricdata.ExponentialBucket{}
+	}
+	first, last := int32(math.MaxInt32), int32(math.MinInt32)
+	for index := range counts {
+		first, last = min(first, index), max(last, index)
+	}
+	bucket := metricdata.ExponentialBucket{Offset: first, Counts: make([]uint64, last-first+1)}
+	for index, count := range counts {
+		bucket.Counts[index-first] = count
+	}
+	return bucket
+}
@@ -0,0 +1,133 @@
+package prometheuslog
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpReceiver is a stub OTLP/HTTP collector, sending every export request
+// it receives to requests.
+func otlpReceiver(t *testing.T, requests chan<- *colmetricpb.ExportMetricsServiceRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request := &colmetricpb.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(body, request); err != nil {
+			t.Errorf("%s: %v", r.URL.Path, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests <- request
+		response, _ := proto.Marshal(&colmetricpb.ExportMetricsServiceResponse{})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(response)
+	}))
+}
+
+func TestOTLPExport(t *testing.T) {
+	requests := make(chan *colmetricpb.ExportMetricsServiceRequest, 10)
+	server := otlpReceiver(t, requests)
+	defer server.Close()
+
+	app, err := New(Config{
+		Environment: "prod",
+		OTLP: OTLPConfig{
+			Endpoint: server.URL + "/v1/metrics",
+			Protocol: OTLPProtocolHTTP,
+			Insecure: true,
+			Interval: time.Hour,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	application := app.AddApplication(0, "myapp", "", 1000, false)
+	registry := application.MetricsRegistry
+	metrics.GetOrRegisterCounter("errors-total", registry).Inc(3)
+	metrics.GetOrRegisterGaugeFloat64("queue-depth", registry).Update(2.5)
+	histogram := metrics.GetOrRegisterHistogram("payload-bytes", registry, metrics.NewUniformSample(1028))
+	for _, value := range []int64{0, 100, 200, 400} {
+		histogram.Update(value)
+	}
+	application.levelCounts.inc("error")
+	application.levelCounts.inc("error")
+
+	// the final export is made when ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	app.wg.Add(1)
+	go application.otlpWorker(ctx)
+	cancel()
+	app.wg.Wait()
+
+	var request *colmetricpb.ExportMetricsServiceRequest
+	select {
+	case request = <-requests:
+	default:
+		t.Fatal("nothing was exported")
+	}
+	if len(request.ResourceMetrics) != 1 {
+		t.Fatalf("%d resources, want 1", len(request.ResourceMetrics))
+	}
+	resource := request.ResourceMetrics[0]
+	attributes := map[string]string{}
+	for _, attribute := range resource.Resource.Attributes {
+		attributes[attribute.Key] = attribute.Value.GetStringValue()
+	}
+	if attributes["service.name"] != "myapp" || attributes["deployment.environment"] != "prod" {
+		t.Errorf("resource attributes %v", attributes)
+	}
+
+	exported := map[string]*metricpb.Metric{}
+	for _, scope := range resource.ScopeMetrics {
+		for _, metric := range scope.Metrics {
+			exported[metric.Name] = metric
+		}
+	}
+	if sum := exported["errors_total"].GetSum(); sum == nil || !sum.IsMonotonic || sum.DataPoints[0].GetAsInt() != 3 {
+		t.Errorf("errors_total = %v, want a monotonic sum of 3", exported["errors_total"])
+	}
+	if gauge := exported["queue_depth"].GetGauge(); gauge == nil || gauge.DataPoints[0].GetAsDouble() != 2.5 {
+		t.Errorf("queue_depth = %v, want a gauge of 2.5", exported["queue_depth"])
+	}
+	if sum := exported["log_messages_total"].GetSum(); sum == nil || len(sum.DataPoints) != 1 ||
+		sum.DataPoints[0].Attributes[0].Value.GetStringValue() != "error" || sum.DataPoints[0].GetAsInt() != 2 {
+		t.Errorf("log_messages_total = %v, want a sum of 2 with level error", exported["log_messages_total"])
+	}
+	histogramData := exported["payload_bytes"].GetExponentialHistogram()
+	if histogramData == nil {
+		t.Fatalf("payload_bytes = %v, want an exponential histogram", exported["payload_bytes"])
+	}
+	point := histogramData.DataPoints[0]
+	var bucketed uint64
+	for _, count := range point.Positive.BucketCounts {
+		bucketed += count
+	}
+	// quantiles interpolate between the observations, so only the total is exact
+	if point.Count != 4 || point.GetSum() != 700 || point.ZeroCount+bucketed != 4 || point.Scale != histogramScale {
+		t.Errorf("payload_bytes count %d sum %g bucketed %d scale %d, want 4, 700, 4 and %d", point.Count, point.GetSum(), point.ZeroCount+bucketed, point.Scale, histogramScale)
+	}
+	if point.GetMin() != 0 || point.GetMax() != 400 {
+		t.Errorf("payload_bytes min %g max %g, want 0 and 400", point.GetMin(), point.GetMax())
+	}
+}
+
+func TestExponentialIndex(t *testing.T) {
+	// bucket index covers (2^(index/4), 2^((index+1)/4)]
+	for value, want := range map[float64]int32{1: -1, 1.1: 0, 2: 3, 2.1: 4, 1024: 39, 0.5: -5} {
+		if got := exponentialIndex(value); got != want {
+			t.Errorf("exponentialIndex(%g) = %d, want %d", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package prometheuslog
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	defaultPushJob     = "prometheuslog"
+	defaultPushRetries = 3
+	defaultPushBackoff = time.Second
+)
+
+// PushConfig configures pushing to a Prometheus Pushgateway, for hosts that
+// can't be scraped. Pushing is disabled when URL is empty.
+type PushConfig struct {
+	URL      string
+	Job      string            // default prometheuslog
+	Grouping map[string]string // default instance=<hostname>
+	Interval time.Duration     // default Config.FlushInterval
+	Retries  int               // attempts after a failed push, default 3
+	Backoff  time.Duration     // wait before the first retry, doubled each retry; default 1s
+}
+
+func (config *PushConfig) setDefaults(flushInterval time.Duration) {
+	if config.Job == "" {
+		config.Job = defaultPushJob
+	}
+	if len(config.Grouping) == 0 {
+		if hostname, err := os.Hostname(); err == nil {
+			config.Grouping = map[string]string{"instance": hostname}
+		}
+	}
+	if config.Interval <= 0 {
+		config.Interval = flushInterval
+	}
+	if config.Retries < 0 {
+		config.Retries = 0
+	} else if config.Retries == 0 {
+		config.Retries = defaultPushRetries
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaultPushBackoff
+	}
+}
+
+// pushWorker pushes every interval, and once more when ctx is done so the
+// Pushgateway holds the final values. The final push, including retries,
+// must finish before the deadline of the ctx given to Stop.
+func (app *App) pushWorker(ctx context.Context) {
+	defer app.wg.Done()
+
+	config := app.Config.Push
+	pusher := push.New(config.URL, config.Job).Collector(app.collector)
+	for name, value := range config.Grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			app.pushWithRetry(ctx, pusher)
+		case <-ctx.Done():
+			app.flushAll()
+			final, cancel := app.finalContext()
+			app.pushWithRetry(final, pusher)
+			cancel()
+			return
+		}
+	}
+}
+
+// pushWithRetry replaces the metrics of our job and grouping on the
+// Pushgateway, retrying with exponential backoff. It gives up instead of
+// waiting past the deadline of ctx.
+func (app *App) pushWithRetry(ctx context.Context, pusher *push.Pusher) {
+	config := app.Config.Push
+	backoff := config.Backoff
+	for attempt := 0; ; attempt++ {
+		err := pusher.PushContext(ctx)
+		if err == nil {
+			return
+		}
+		deadline, hasDeadline := ctx.Deadline()
+		if attempt >= config.Retries || hasDeadline && time.Now().Add(backoff).After(deadline) {
+			app.Config.Logger.Error("unable to push metrics", "url", config.URL, "attempts", attempt+1, "error", err)
+			return
+		}
+		app.Config.Logger.Warn("push failed, retrying", "url", config.URL, "backoff", backoff.String(), "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+	}
+}
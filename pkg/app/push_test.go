@@ -0,0 +1,169 @@
+package prometheuslog
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rcrowley/go-metrics"
+)
+
+// pushgateway is a stub Pushgateway that fails the first failures pushes
+// and records the metric families of the last accepted one.
+type pushgateway struct {
+	sync.Mutex
+	failures int
+	requests int
+	path     string
+	families map[string]*dto.MetricFamily
+}
+
+func (gateway *pushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	gateway.Lock()
+	defer gateway.Unlock()
+	gateway.requests++
+	if gateway.failures > 0 {
+		gateway.failures--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	families := map[string]*dto.MetricFamily{}
+	decoder := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+	for {
+		family := &dto.MetricFamily{}
+		if err := decoder.Decode(family); err == io.EOF {
+			break
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		families[family.GetName()] = family
+	}
+	gateway.path, gateway.families = r.URL.Path, families
+	w.WriteHeader(http.StatusOK)
+}
+
+// value returns the value of the series of a pushed family with the given
+// label, or -1.
+func (gateway *pushgateway) value(name string, labelName string, labelValue string) float64 {
+	gateway.Lock()
+	defer gateway.Unlock()
+	for _, metric := range gateway.families[name].GetMetric() {
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == labelName && label.GetValue() == labelValue {
+				switch {
+				case metric.Counter != nil:
+					return metric.Counter.GetValue()
+				case metric.Gauge != nil:
+					return metric.Gauge.GetValue()
+				}
+				return metric.Untyped.GetValue()
+			}
+		}
+	}
+	return -1
+}
+
+func TestPushOnStop(t *testing.T) {
+	gateway := &pushgateway{}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	logPath := filepath.Join(t.TempDir(), "myapp.log")
+	if err := os.WriteFile(logPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	app, err := New(Config{
+		Environment:   "prod",
+		FlushInterval: time.Hour,
+		Watch:         WatchPoll,
+		PollInterval:  10 * time.Millisecond,
+		Push:          PushConfig{URL: server.URL, Grouping: map[string]string{"instance": "test"}, Interval: time.Hour},
+		Rules:         []Rule{{Name: "errors", Contains: "ERROR", Metric: "errors-total"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// with labels, metrics are read from the registry when gathered
+	app.AddApplication(0, "myapp", logPath, 1000, false).Labels = map[string]string{"team": "payments"}
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("2026-10-18 12:00:00 ERROR payment failed\n")
+	file.Close()
+	application := app.findApplication("myapp")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if counter, ok := application.MetricsRegistry.Get("errors-total").(metrics.Counter); ok && counter.Count() == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the log line was not processed")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := app.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if gateway.requests != 1 {
+		t.Fatalf("%d pushes, want 1 on stop", gateway.requests)
+	}
+	if gateway.path != "/metrics/job/prometheuslog/instance/test" {
+		t.Errorf("pushed to %s", gateway.path)
+	}
+	if got := gateway.value("myapp_prod_errors_total", "team", "payments"); got != 1 {
+		t.Errorf("myapp_prod_errors_total = %g, want 1", got)
+	}
+	if got := gateway.value("myapp_prod_log_messages_total", "level", "error"); got != 1 {
+		t.Errorf("myapp_prod_log_messages_total{level=\"error\"} = %g, want 1", got)
+	}
+}
+
+func TestPushRetries(t *testing.T) {
+	gateway := &pushgateway{failures: 2}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	app := NewApp()
+	app.Config.Push = PushConfig{URL: server.URL, Backoff: 10 * time.Millisecond}
+	app.Config.Push.setDefaults(time.Second)
+	app.pushWithRetry(context.Background(), push.New(server.URL, "test").Collector(app.collector))
+	if gateway.requests != 3 || gateway.families == nil {
+		t.Errorf("%d pushes, accepted %v; want 3 with the last accepted", gateway.requests, gateway.families != nil)
+	}
+}
+
+func TestPushRetriesStopAtDeadline(t *testing.T) {
+	gateway := &pushgateway{failures: 100}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	app := NewApp()
+	app.Config.Push = PushConfig{URL: server.URL, Backoff: time.Second}
+	app.Config.Push.setDefaults(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	app.pushWithRetry(ctx, push.New(server.URL, "test").Collector(app.collector))
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("gave up after %s, past the deadline", elapsed)
+	}
+	if gateway.requests != 1 {
+		t.Errorf("%d pushes, want 1", gateway.requests)
+	}
+}
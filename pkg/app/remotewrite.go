@@ -0,0 +1,378 @@
+package prometheuslog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	defaultRemoteWriteBatchSize     = 500
+	defaultRemoteWriteTimeout       = 30 * time.Second
+	defaultRemoteWriteRetries       = 3
+	defaultRemoteWriteBackoff       = time.Second
+	defaultRemoteWriteMaxBufferSize = 100 * 1024 * 1024
+)
+
+// RemoteWriteConfig configures sending samples to a Prometheus remote_write
+// endpoint (Prometheus, Mimir, Cortex, etc). It is disabled when URL is
+// empty.
+type RemoteWriteConfig struct {
+	URL             string
+	ExternalLabels  map[string]string // added to every series, e.g. site=edge-01
+	Interval        time.Duration     // default Config.FlushInterval
+	BatchSize       int               // samples per request, default 500
+	Timeout         time.Duration     // per request, default 30s
+	Retries         int               // attempts after a failed request, default 3
+	Backoff         time.Duration     // wait before the first retry, doubled each retry; default 1s
+	BufferDirectory string            // requests that could not be sent are kept here and resent later; disabled when empty
+	MaxBufferSize   int64             // oldest buffered requests are dropped above this many bytes, default 100MB
+}
+
+func (config *RemoteWriteConfig) setDefaults(flushInterval time.Duration) {
+	if config.Interval <= 0 {
+		config.Interval = flushInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultRemoteWriteBatchSize
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultRemoteWriteTimeout
+	}
+	if config.Retries < 0 {
+		config.Retries = 0
+	} else if config.Retries == 0 {
+		config.Retries = defaultRemoteWriteRetries
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaultRemoteWriteBackoff
+	}
+	if config.MaxBufferSize <= 0 {
+		config.MaxBufferSize = defaultRemoteWriteMaxBufferSize
+	}
+}
+
+// remoteWriter sends the App's metrics with the remote_write protocol:
+// snappy compressed protobuf WriteRequests.
+type remoteWriter struct {
+	app    *App
+	config RemoteWriteConfig
+	client *http.Client
+
+	samplesSent     prometheus.Counter
+	samplesFailed   prometheus.Counter
+	samplesBuffered prometheus.Counter
+	bufferBytes     prometheus.Gauge
+}
+
+func newRemoteWriter(app *App) *remoteWriter {
+	writer := &remoteWriter{
+		app:    app,
+		config: app.Config.RemoteWrite,
+		client: &http.Client{Timeout: app.Config.RemoteWrite.Timeout},
+		samplesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheuslog_remote_write_samples_sent_total",
+			Help: "Samples accepted by the remote_write endpoint.",
+		}),
+		samplesFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheuslog_remote_write_samples_failed_total",
+			Help: "Samples rejected by the remote_write endpoint, or dropped from a full buffer.",
+		}),
+		samplesBuffered: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheuslog_remote_write_samples_buffered_total",
+			Help: "Samples written to the on-disk buffer because the remote_write endpoint was unreachable.",
+		}),
+		bufferBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "prometheuslog_remote_write_buffer_bytes",
+			Help: "Size of the remote_write on-disk buffer.",
+		}),
+	}
+	app.collector.MustRegister(writer.samplesSent, writer.samplesFailed, writer.samplesBuffered, writer.bufferBytes)
+	return writer
+}
+
+// remoteWriteWorker sends every interval, and once more when ctx is done,
+// within the deadline of Stop.
+func (app *App) remoteWriteWorker(ctx context.Context) {
+	defer app.wg.Done()
+
+	writer := newRemoteWriter(app)
+	ticker := time.NewTicker(writer.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			writer.send(ctx)
+		case <-ctx.Done():
+			final, cancel := app.finalContext()
+			writer.send(final)
+			cancel()
+			return
+		}
+	}
+}
+
+// send resends buffered requests, oldest first, then sends the current
+// value of every metric in batches.
+func (writer *remoteWriter) send(ctx context.Context) {
+	unreachable := !writer.flushBuffer(ctx)
+
+	families, err := writer.app.gatherer().Gather()
+	if err != nil {
+		writer.app.Config.Logger.Error("remote_write: unable to gather metrics", "error", err)
+		return
+	}
+	series := writer.timeSeries(families, time.Now())
+	for start := 0; start < len(series); start += writer.config.BatchSize {
+		end := start + writer.config.BatchSize
+		if end > len(series) {
+			end = len(series)
+		}
+		request := snappy.Encode(nil, encodeWriteRequest(series[start:end]))
+		samples := end - start
+
+		if unreachable {
+			writer.buffer(request, samples)
+			continue
+		}
+		err := writer.post(ctx, request)
+		switch {
+		case err == nil:
+			writer.samplesSent.Add(float64(samples))
+		case isRecoverable(err):
+			writer.app.Config.Logger.Error("remote_write: endpoint unreachable", "url", writer.config.URL, "error", err)
+			unreachable = true
+			writer.buffer(request, samples)
+		default:
+			writer.app.Config.Logger.Error("remote_write: samples rejected", "url", writer.config.URL, "samples", samples, "error", err)
+			writer.samplesFailed.Add(float64(samples))
+		}
+	}
+}
+
+// post sends one compressed WriteRequest, retrying recoverable errors with
+// exponential backoff.
+func (writer *remoteWriter) post(ctx context.Context, request []byte) error {
+	backoff := writer.config.Backoff
+	for attempt := 0; ; attempt++ {
+		err := writer.postOnce(ctx, request)
+		if err == nil || !isRecoverable(err) || attempt >= writer.config.Retries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (writer *remoteWriter) postOnce(ctx context.Context, request []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, writer.config.URL, bytes.NewReader(request))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "prometheuslog")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := writer.client.Do(req)
+	if err != nil {
+		return recoverableError{err}
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
+
+// recoverableError is a network error or a 5xx/429 response: the request
+// may succeed later, so it is retried and then buffered.
+type recoverableError struct {
+	error
+}
+
+func isRecoverable(err error) bool {
+	_, ok := err.(recoverableError)
+	return ok
+}
+
+// buffer keeps a request that could not be sent in the buffer directory,
+// dropping the oldest requests above MaxBufferSize.
+func (writer *remoteWriter) buffer(request []byte, samples int) {
+	if writer.config.BufferDirectory == "" {
+		writer.samplesFailed.Add(float64(samples))
+		return
+	}
+	if err := os.MkdirAll(writer.config.BufferDirectory, 0755); err != nil {
+		writer.app.Config.Logger.Error("remote_write: unable to create buffer directory", "error", err)
+		writer.samplesFailed.Add(float64(samples))
+		return
+	}
+	// The sample count is kept in the name so dropped requests can be counted.
+	name := fmt.Sprintf("%020d-%d.snappy", time.Now().UnixNano(), samples)
+	if err := os.WriteFile(filepath.Join(writer.config.BufferDirectory, name), request, 0644); err != nil {
+		writer.app.Config.Logger.Error("remote_write: unable to buffer request", "error", err)
+		writer.samplesFailed.Add(float64(samples))
+		return
+	}
+	writer.samplesBuffered.Add(float64(samples))
+
+	files, size := writer.bufferedFiles()
+	for len(files) > 0 && size > writer.config.MaxBufferSize {
+		info, err := os.Stat(files[0])
+		if err == nil {
+			size -= info.Size()
+		}
+		os.Remove(files[0])
+		writer.samplesFailed.Add(float64(bufferedSamples(files[0])))
+		files = files[1:]
+	}
+	writer.bufferBytes.Set(float64(size))
+}
+
+// flushBuffer resends buffered requests, oldest first, and reports whether
+// the endpoint is reachable.
+func (writer *remoteWriter) flushBuffer(ctx context.Context) bool {
+	if writer.config.BufferDirectory == "" {
+		return true
+	}
+	files, size := writer.bufferedFiles()
+	defer func() { writer.bufferBytes.Set(float64(size)) }()
+	for _, file := range files {
+		request, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		samples := bufferedSamples(file)
+		err = writer.postOnce(ctx, request)
+		if err != nil && isRecoverable(err) {
+			return false
+		}
+		if err == nil {
+			writer.samplesSent.Add(float64(samples))
+		} else {
+			writer.samplesFailed.Add(float64(samples))
+		}
+		os.Remove(file)
+		size -= int64(len(request))
+	}
+	return true
+}
+
+func (writer *remoteWriter) bufferedFiles() ([]string, int64) {
+	files, _ := filepath.Glob(filepath.Join(writer.config.BufferDirectory, "*.snappy"))
+	sort.Strings(files)
+	var size int64
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			size += info.Size()
+		}
+	}
+	return files, size
+}
+
+func bufferedSamples(file string) int {
+	var timestamp int64
+	var samples int
+	fmt.Sscanf(filepath.Base(file), "%d-%d.snappy", &timestamp, &samples)
+	return samples
+}
+
+type remoteSeries struct {
+	labels    [][2]string // sorted by name, __name__ first
+	value     float64
+	timestamp int64 // milliseconds
+}
+
+// timeSeries converts gathered metric families into one sample per series.
+// Histograms and summaries are not produced by prometheuslog and are skipped.
+func (writer *remoteWriter) timeSeries(families []*dto.MetricFamily, now time.Time) []remoteSeries {
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+	var series []remoteSeries
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var value float64
+			switch {
+			case metric.Gauge != nil:
+				value = metric.GetGauge().GetValue()
+			case metric.Counter != nil:
+				value = metric.GetCounter().GetValue()
+			case metric.Untyped != nil:
+				value = metric.GetUntyped().GetValue()
+			default:
+				continue
+			}
+
+			labels := map[string]string{}
+			for name, labelValue := range writer.config.ExternalLabels {
+				labels[name] = labelValue
+			}
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			labels["__name__"] = family.GetName()
+
+			s := remoteSeries{value: value, timestamp: timestamp}
+			for name, labelValue := range labels {
+				s.labels = append(s.labels, [2]string{name, labelValue})
+			}
+			sort.Slice(s.labels, func(i, j int) bool { return s.labels[i][0] < s.labels[j][0] })
+			series = append(series, s)
+		}
+	}
+	return series
+}
+
+// encodeWriteRequest encodes a prometheus.WriteRequest:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []remoteSeries) []byte {
+	var request []byte
+	for _, s := range series {
+		var ts []byte
+		for _, label := range s.labels {
+			var l []byte
+			l = protowire.AppendTag(l, 1, protowire.BytesType)
+			l = protowire.AppendString(l, label[0])
+			l = protowire.AppendTag(l, 2, protowire.BytesType)
+			l = protowire.AppendString(l, label[1])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, l)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, ts)
+	}
+	return request
+}
@@ -0,0 +1,230 @@
+package prometheuslog
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/rcrowley/go-metrics"
+)
+
+// remoteWriteReceiver is a stub remote_write endpoint. It answers with the
+// next of statuses, then 204, and records the requests it accepts.
+type remoteWriteReceiver struct {
+	sync.Mutex
+	statuses []int
+	attempts int
+	accepted []*prompb.WriteRequest
+}
+
+func (receiver *remoteWriteReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	receiver.Lock()
+	defer receiver.Unlock()
+	receiver.attempts++
+	if len(receiver.statuses) > 0 {
+		status := receiver.statuses[0]
+		receiver.statuses = receiver.statuses[1:]
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		http.Error(w, "unexpected encoding", http.StatusBadRequest)
+		return
+	}
+	compressed, _ := io.ReadAll(r.Body)
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request := &prompb.WriteRequest{}
+	if err := request.Unmarshal(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	receiver.accepted = append(receiver.accepted, request)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// remoteWriteApp starts an App with one labeled application having an
+// errors counter of 3, and returns a remote writer for it.
+func remoteWriteApp(t *testing.T, config RemoteWriteConfig) (*App, *remoteWriter) {
+	t.Helper()
+	app, err := New(Config{IngestTokens: []string{"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	application := app.AddApplication(0, "myapp", IngestPath, 1000, false)
+	application.Labels = map[string]string{"team": "payments"}
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.Stop(context.Background()) })
+	metrics.GetOrRegisterCounter("errors-total", application.MetricsRegistry).Inc(3)
+
+	app.Config.RemoteWrite = config
+	app.Config.RemoteWrite.setDefaults(time.Hour)
+	return app, newRemoteWriter(app)
+}
+
+func TestRemoteWrite(t *testing.T) {
+	receiver := &remoteWriteReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	_, writer := remoteWriteApp(t, RemoteWriteConfig{URL: server.URL, ExternalLabels: map[string]string{"site": "edge-01"}})
+	before := time.Now().UnixMilli()
+	writer.send(context.Background())
+	after := time.Now().UnixMilli()
+
+	if len(receiver.accepted) != 1 {
+		t.Fatalf("%d requests accepted, want 1", len(receiver.accepted))
+	}
+	var found *prompb.TimeSeries
+	for i, series := range receiver.accepted[0].Timeseries {
+		for _, label := range series.Labels {
+			if label.Name == "__name__" && label.Value == "myapp_prod_errors_total" {
+				found = &receiver.accepted[0].Timeseries[i]
+			}
+		}
+	}
+	if found == nil {
+		t.Fatalf("myapp_prod_errors_total was not sent: %v", receiver.accepted[0])
+	}
+	want := []prompb.Label{{Name: "__name__", Value: "myapp_prod_errors_total"}, {Name: "site", Value: "edge-01"}, {Name: "team", Value: "payments"}}
+	if len(found.Labels) != len(want) {
+		t.Fatalf("labels %v, want %v", found.Labels, want)
+	}
+	for i := range want {
+		if found.Labels[i].Name != want[i].Name || found.Labels[i].Value != want[i].Value {
+			t.Errorf("labels %v, want %v", found.Labels, want)
+			break
+		}
+	}
+	if len(found.Samples) != 1 || found.Samples[0].Value != 3 {
+		t.Fatalf("samples %v, want one of 3", found.Samples)
+	}
+	if timestamp := found.Samples[0].Timestamp; timestamp < before || timestamp > after {
+		t.Errorf("timestamp %d, want between %d and %d", timestamp, before, after)
+	}
+	if got, sent := counterValue(t, writer.samplesSent), len(receiver.accepted[0].Timeseries); got != float64(sent) {
+		t.Errorf("samples sent %g, want %d", got, sent)
+	}
+}
+
+func TestRemoteWriteBatches(t *testing.T) {
+	receiver := &remoteWriteReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	_, writer := remoteWriteApp(t, RemoteWriteConfig{URL: server.URL, BatchSize: 1})
+	writer.send(context.Background())
+	if len(receiver.accepted) < 2 {
+		t.Fatalf("%d requests, want one per sample", len(receiver.accepted))
+	}
+	for _, request := range receiver.accepted {
+		if len(request.Timeseries) != 1 {
+			t.Errorf("%d series in a request, want 1", len(request.Timeseries))
+		}
+	}
+}
+
+func TestRemoteWriteRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		sent     bool
+	}{
+		{name: "5xx is retried", statuses: []int{503, 500}, attempts: 3, sent: true},
+		{name: "429 is retried", statuses: []int{429}, attempts: 2, sent: true},
+		{name: "4xx is dropped", statuses: []int{400}, attempts: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := &remoteWriteReceiver{statuses: test.statuses}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			_, writer := remoteWriteApp(t, RemoteWriteConfig{URL: server.URL, Backoff: time.Millisecond, BatchSize: 1000})
+			writer.send(context.Background())
+			if receiver.attempts != test.attempts {
+				t.Errorf("%d attempts, want %d", receiver.attempts, test.attempts)
+			}
+			sent, failed := counterValue(t, writer.samplesSent), counterValue(t, writer.samplesFailed)
+			if test.sent && (sent == 0 || failed != 0) {
+				t.Errorf("%g samples sent and %g failed, want all sent", sent, failed)
+			}
+			if !test.sent && (sent != 0 || failed == 0) {
+				t.Errorf("%g samples sent and %g failed, want all dropped", sent, failed)
+			}
+		})
+	}
+}
+
+func TestRemoteWriteBuffer(t *testing.T) {
+	receiver := &remoteWriteReceiver{statuses: []int{503}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	directory := t.TempDir()
+	_, writer := remoteWriteApp(t, RemoteWriteConfig{URL: server.URL, Retries: -1, BufferDirectory: directory})
+
+	// unreachable: the request is kept on disk
+	writer.send(context.Background())
+	buffered, _ := os.ReadDir(directory)
+	if len(buffered) != 1 || counterValue(t, writer.samplesBuffered) == 0 {
+		t.Fatalf("%d requests buffered, want 1", len(buffered))
+	}
+
+	// reachable again: the buffered request is resent first
+	writer.send(context.Background())
+	if len(receiver.accepted) != 2 {
+		t.Fatalf("%d requests accepted, want the buffered one and the new one", len(receiver.accepted))
+	}
+	if remaining, _ := os.ReadDir(directory); len(remaining) != 0 {
+		t.Errorf("%d requests still buffered", len(remaining))
+	}
+	if sent, buffered := counterValue(t, writer.samplesSent), counterValue(t, writer.samplesBuffered); sent != 2*buffered {
+		t.Errorf("%g samples sent, want the %g buffered twice", sent, buffered)
+	}
+}
+
+func TestRemoteWriteStopDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	app, err := New(Config{RemoteWrite: RemoteWriteConfig{URL: server.URL, Interval: time.Hour}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	app.Stop(ctx)
+
+	// the final send to the hanging endpoint gives up at the deadline
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the final remote write outlived the Stop deadline")
+	}
+}
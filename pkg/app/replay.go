@@ -0,0 +1,116 @@
+package prometheuslog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// maxLineLength is the longest log line Replay will read.
+const maxLineLength = 1024 * 1024
+
+// ReplayResult is the outcome of replaying a log through the rules.
+type ReplayResult struct {
+	Lines       int
+	Metrics     map[string]float64 // by exposed metric name, with labels for log_messages_total
+	RuleMatches map[string]int64   // by rule name, declarative rules only
+}
+
+// Replay reads r from the beginning and runs every line through the same
+// parsing and rules as a tailed log, without the rate limiter. The lines are
+// attributed to applicationName, which does not need to be configured, and
+// decoded in its Format.
+func (app *App) Replay(applicationName string, r io.Reader) (*ReplayResult, error) {
+	application := app.findApplication(applicationName)
+	if application == nil {
+		application = app.AddApplication(len(app.Applications), applicationName, "", app.Config.MaxIngestionRate, app.Config.Debug)
+	}
+	app.Lock()
+	application.prepare()
+	app.Unlock()
+
+	var input Input = newReaderInput(func() (io.ReadCloser, error) { return io.NopCloser(r), nil }, false)
+	if application.Format != "" {
+		input = newDecoderInput(input, application.Format)
+	}
+	defer input.Close()
+
+	meter := metrics.GetOrRegisterCounter("apm-log-read-rate", application.MetricsRegistry)
+	result := &ReplayResult{}
+	for line := range input.Lines() {
+		application.processLine(line)
+		meter.Inc(1)
+		application.TotalLinesRead++
+		result.Lines++
+	}
+	if err := input.Err(); err != nil {
+		return nil, err
+	}
+
+	result.Metrics = registryValues(application.MetricsRegistry, applicationName, app.Config.Environment)
+	application.exposedLevelValues(applicationName, func(name string, value float64) {
+		result.Metrics[name] = value
+	})
+	result.RuleMatches = map[string]int64{}
+	for i, rule := range application.rules {
+		if rule.Func == nil {
+			result.RuleMatches[rule.Name] += application.ruleMatches[i]
+		}
+	}
+	return result, nil
+}
+
+// String formats the result one value per line, sorted, so it can be
+// compared with an expected output file.
+func (result *ReplayResult) String() string {
+	var lines []string
+	for name, value := range result.Metrics {
+		lines = append(lines, fmt.Sprintf("metric %s %g", name, value))
+	}
+	for name, count := range result.RuleMatches {
+		lines = append(lines, fmt.Sprintf("rule %s %d", name, count))
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("lines %d\n%s\n", result.Lines, strings.Join(lines, "\n"))
+}
+
+func (app *App) findApplication(applicationName string) *Application {
+	app.Lock()
+	defer app.Unlock()
+	for _, application := range app.Applications {
+		if application.ApplicationName == applicationName {
+			return application
+		}
+	}
+	return nil
+}
+
+// registryValues returns the current value of every counter and gauge in a
+// go-metrics registry, by exposed metric name.
+func registryValues(registry metrics.Registry, applicationName string, environment string) map[string]float64 {
+	values := map[string]float64{}
+	eachValue(registry, func(name string, value float64) {
+		values[metricName(applicationName, environment, name)] = value
+	})
+	return values
+}
+
+// eachValue calls fn with the registry name and current value of every
+// counter, gauge and meter in a go-metrics registry.
+func eachValue(registry metrics.Registry, fn func(name string, value float64)) {
+	registry.Each(func(name string, i interface{}) {
+		switch metric := i.(type) {
+		case metrics.Counter:
+			fn(name, float64(metric.Count()))
+		case metrics.Gauge:
+			fn(name, float64(metric.Value()))
+		case metrics.GaugeFloat64:
+			fn(name, metric.Value())
+		case metrics.Meter:
+			fn(name, float64(metric.Count()))
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package prometheuslog
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// RuleFunc is a custom rule written in Go. Like the parse functions in
+// common.go it is executed once per log line.
+type RuleFunc func(line string, applicationName string, registry metrics.Registry, debug bool)
+
+const (
+	RuleTypeCounter = "counter"
+	RuleTypeGauge   = "gauge"
+)
+
+// Rule updates a single metric when a log line matches. A rule either wraps
+// a RuleFunc, or declares a prefilter, an optional regex and the metric to
+// update. In a rules file:
+//
+//	[
+//	  {"name": "alert-created", "contains": "postPayloadStarted", "metric": "apm-alert-created-total"},
+//	  {"name": "scrape-duration", "contains": "scrapeExecuteFinished", "regex": "duration=([0-9]+)ms",
+//	   "metric": "apm-webharvest-exit-duration", "type": "gauge", "value": "1"}
+//	]
+//
+// Counters are incremented by one, or by the captured value when Value is
+// set. Gauges are set to the captured value. Value is a capture group number
+// or name.
+//
+// A rule with Fields only applies to lines whose fields match the given glob
+// patterns. Fields are parsed by the input, such as the syslog severity, or
+// are the labels of a discovered Kubernetes container, which selects the
+// rules of a namespace or container: {"namespace": "payments-*"}.
+//
+// Instead of Metric, a rule may give a Starlark Script (or ScriptFile) for
+// logic a declarative rule can't express; see scriptFunction.
+type Rule struct {
+	Name        string            `json:"name"`
+	Application string            `json:"application,omitempty"` // only apply to this application; all when empty
+	Fields      map[string]string `json:"fields,omitempty"`      // only apply when these fields match these glob patterns, e.g. {"severity": "err"}
+	Contains    string            `json:"contains,omitempty"`    // cheap strings.Contains prefilter
+	Regex       string            `json:"regex,omitempty"`
+	Metric      string            `json:"metric,omitempty"`
+	Type        string            `json:"type,omitempty"` // counter (default) or gauge
+	Value       string            `json:"value,omitempty"`
+	Script      string            `json:"script,omitempty"`
+	ScriptFile  string            `json:"scriptFile,omitempty"`
+	Timeout     string            `json:"timeout,omitempty"` // per call script time limit, default 100ms
+	Func        RuleFunc          `json:"-"`
+
+	regex      *regexp.Regexp
+	valueIndex int
+	script     *ruleScript
+}
+
+func (rule *Rule) compile() error {
+	if rule.Func != nil {
+		return nil
+	}
+	for name, pattern := range rule.Fields {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("rule %q: invalid pattern %q for field %s", rule.Name, pattern, name)
+		}
+	}
+	if rule.Regex != "" {
+		regex, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return fmt.Errorf("rule %q: %v", rule.Name, err)
+		}
+		rule.regex = regex
+	}
+	if rule.Script != "" || rule.ScriptFile != "" {
+		script, err := compileScript(rule)
+		if err != nil {
+			return err
+		}
+		rule.script = script
+		return nil
+	}
+	if rule.Metric == "" {
+		return fmt.Errorf("rule %q: metric is required", rule.Name)
+	}
+	if rule.Type == "" {
+		rule.Type = RuleTypeCounter
+	}
+	if rule.Type != RuleTypeCounter && rule.Type != RuleTypeGauge {
+		return fmt.Errorf("rule %q: unknown type %q", rule.Name, rule.Type)
+	}
+	if rule.Value == "" {
+		if rule.Type == RuleTypeGauge {
+			return fmt.Errorf("rule %q: gauges need a value capture group", rule.Name)
+		}
+		return nil
+	}
+	if rule.regex == nil {
+		return fmt.Errorf("rule %q: value %q needs a regex", rule.Name, rule.Value)
+	}
+	index, err := strconv.Atoi(rule.Value)
+	if err != nil {
+		index = rule.regex.SubexpIndex(rule.Value)
+	}
+	if index <= 0 || index > rule.regex.NumSubexp() {
+		return fmt.Errorf("rule %q: regex has no capture group %q", rule.Name, rule.Value)
+	}
+	rule.valueIndex = index
+	return nil
+}
+
+// fieldsMatch reports whether every field selected by the rule matches.
+func (rule *Rule) fieldsMatch(fields Fields) bool {
+	for name, pattern := range rule.Fields {
+		if matched, _ := path.Match(pattern, fields[name]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// apply runs the rule on a line and reports whether it matched. RuleFunc
+// rules always report false.
+func (rule *Rule) apply(dashBoard *App, line string, fields Fields, registry metrics.Registry, debug bool) bool {
+	applicationName := fields["application"]
+	if rule.Application != "" && rule.Application != applicationName {
+		return false
+	}
+	if !rule.fieldsMatch(fields) {
+		return false
+	}
+	if rule.Func != nil {
+		rule.Func(line, applicationName, registry, debug)
+		return false
+	}
+	if rule.Contains != "" && !strings.Contains(line, rule.Contains) {
+		return false
+	}
+	var submatch []string
+	if rule.regex != nil {
+		submatch = rule.regex.FindStringSubmatch(line)
+		if submatch == nil {
+			return false
+		}
+	}
+
+	if rule.script != nil {
+		err := rule.script.run(line, scriptFields(fields, rule, submatch), registrySink{registry})
+		if err != nil {
+			dashBoard.writeDebugMessage(debug, fmt.Sprintf("Rule - %s - %v", rule.Name, err), applicationName)
+		}
+		return true
+	}
+
+	switch rule.Type {
+	case RuleTypeGauge:
+		s, err := strconv.ParseFloat(submatch[rule.valueIndex], 64)
+		if err != nil {
+			return false
+		}
+		meter := metrics.GetOrRegisterGaugeFloat64(rule.Metric, registry)
+		meter.Update(s)
+	default:
+		inc := int64(1)
+		if rule.valueIndex > 0 {
+			valInt, err := strconv.ParseInt(submatch[rule.valueIndex], 10, 64)
+			if err != nil {
+				return false
+			}
+			inc = valInt
+		}
+		counter := metrics.GetOrRegisterCounter(rule.Metric, registry)
+		counter.Inc(inc)
+	}
+	dashBoard.writeDebugMessage(debug, fmt.Sprintf("Rule - %s", rule.Name), applicationName)
+	return true
+}
@@ -0,0 +1,155 @@
+package prometheuslog
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// defaultScriptTimeout bounds a single call of a rule script.
+const defaultScriptTimeout = 100 * time.Millisecond
+
+// scriptFunction is the function every rule script must define. It is
+// called once per matching line:
+//
+//	def process(line, fields):
+//	    gauge("apm-common-memoryused-bytes", int(fields["total"]) - int(fields["free"]))
+//
+// fields holds "application" plus every regex capture group, by number and
+// by name. Scripts emit metrics with counter(name, delta=1) and
+// gauge(name, value). They run in a Starlark interpreter with no file,
+// network or module access.
+const scriptFunction = "process"
+
+type ruleScript struct {
+	name    string
+	process starlark.Value
+	timeout time.Duration
+}
+
+func compileScript(rule *Rule) (*ruleScript, error) {
+	src := rule.Script
+	filename := rule.Name + ".star"
+	if rule.ScriptFile != "" {
+		data, err := os.ReadFile(rule.ScriptFile)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %v", rule.Name, err)
+		}
+		src = string(data)
+		filename = rule.ScriptFile
+	}
+
+	timeout := defaultScriptTimeout
+	if rule.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(rule.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: timeout: %v", rule.Name, err)
+		}
+	}
+
+	thread := &starlark.Thread{Name: rule.Name}
+	globals, err := starlark.ExecFile(thread, filename, src, scriptBuiltins)
+	if err != nil {
+		return nil, fmt.Errorf("rule %q: %v", rule.Name, err)
+	}
+	process, ok := globals[scriptFunction].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("rule %q: script must define %s(line, fields)", rule.Name, scriptFunction)
+	}
+	globals.Freeze()
+
+	return &ruleScript{
+		name:    rule.Name,
+		process: process,
+		timeout: timeout,
+	}, nil
+}
+
+// run calls the script's process function. Errors and timeouts are counted
+// in the application's registry rather than stopping the worker.
+func (script *ruleScript) run(line string, fields Fields, sink MetricsSink) error {
+	dict := starlark.NewDict(len(fields))
+	for key, value := range fields {
+		dict.SetKey(starlark.String(key), starlark.String(value))
+	}
+
+	thread := &starlark.Thread{Name: script.name}
+	thread.SetLocal("sink", sink)
+	timer := time.AfterFunc(script.timeout, func() {
+		thread.Cancel(fmt.Sprintf("exceeded %s", script.timeout))
+	})
+	_, err := starlark.Call(thread, script.process, starlark.Tuple{starlark.String(line), dict}, nil)
+	expired := !timer.Stop()
+	if err == nil {
+		return nil
+	}
+
+	if expired {
+		sink.IncCounter(fmt.Sprintf("rule-%s-script-timeouts-total", script.name), 1)
+	} else {
+		sink.IncCounter(fmt.Sprintf("rule-%s-script-errors-total", script.name), 1)
+	}
+	return err
+}
+
+// scriptFields returns the fields passed to a rule script.
+func scriptFields(lineFields Fields, rule *Rule, submatch []string) Fields {
+	fields := Fields{}
+	for name, value := range lineFields {
+		fields[name] = value
+	}
+	if rule.regex == nil {
+		return fields
+	}
+	for i, name := range rule.regex.SubexpNames() {
+		if i == 0 || i >= len(submatch) {
+			continue
+		}
+		fields[strconv.Itoa(i)] = submatch[i]
+		if name != "" {
+			fields[name] = submatch[i]
+		}
+	}
+	return fields
+}
+
+var scriptBuiltins = starlark.StringDict{
+	"counter": starlark.NewBuiltin("counter", scriptCounter),
+	"gauge":   starlark.NewBuiltin("gauge", scriptGauge),
+}
+
+func scriptCounter(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	delta := 1
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "delta?", &delta); err != nil {
+		return nil, err
+	}
+	sink, ok := thread.Local("sink").(MetricsSink)
+	if !ok {
+		return nil, fmt.Errorf("%s: only available inside %s", fn.Name(), scriptFunction)
+	}
+	sink.IncCounter(name, int64(delta))
+	return starlark.None, nil
+}
+
+func scriptGauge(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var value starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "value", &value); err != nil {
+		return nil, err
+	}
+	f, ok := starlark.AsFloat(value)
+	if !ok {
+		return nil, fmt.Errorf("%s: value must be a number, not %s", fn.Name(), value.Type())
+	}
+	sink, ok := thread.Local("sink").(MetricsSink)
+	if !ok {
+		return nil, fmt.Errorf("%s: only available inside %s", fn.Name(), scriptFunction)
+	}
+	sink.SetGauge(name, f)
+	return starlark.None, nil
+}
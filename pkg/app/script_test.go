@@ -0,0 +1,77 @@
+package prometheuslog
+
+import (
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestScriptRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		counters map[string]int64
+	}{
+		{
+			name:   "emits",
+			script: "def process(line, fields):\n    counter('lines-total', 2)\n    gauge('length', len(line))\n",
+			counters: map[string]int64{
+				"lines-total":                    2,
+				"rule-emits-script-errors-total": 0,
+			},
+		},
+		{
+			name:   "fails",
+			script: "def process(line, fields):\n    fail('broken')\n",
+			counters: map[string]int64{
+				"rule-fails-script-errors-total":   1,
+				"rule-fails-script-timeouts-total": 0,
+			},
+		},
+		{
+			name:   "loops",
+			script: "def process(line, fields):\n    for i in range(1000000000):\n        pass\n",
+			counters: map[string]int64{
+				"rule-loops-script-timeouts-total": 1,
+				"rule-loops-script-errors-total":   0,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule := Rule{Name: test.name, Script: test.script, Timeout: "20ms"}
+			if err := rule.compile(); err != nil {
+				t.Fatal(err)
+			}
+			registry := metrics.NewRegistry()
+			if !rule.apply(NewApp(), "a line", Fields{"application": "myapp"}, registry, false) {
+				t.Fatal("rule did not match")
+			}
+			for name, want := range test.counters {
+				var got int64
+				if counter, ok := registry.Get(name).(metrics.Counter); ok {
+					got = counter.Count()
+				}
+				if got != want {
+					t.Errorf("%s = %d, want %d", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestScriptRuleCompileErrors(t *testing.T) {
+	for name, script := range map[string]string{
+		"syntax":     "def process(line, fields)\n",
+		"no-process": "def handle(line, fields):\n    pass\n",
+	} {
+		rule := Rule{Name: name, Script: script}
+		if err := rule.compile(); err == nil {
+			t.Errorf("%s: expected a compile error", name)
+		}
+	}
+	rule := Rule{Name: "timeout", Script: "def process(line, fields):\n    pass\n", Timeout: "soon"}
+	if err := rule.compile(); err == nil {
+		t.Error("expected an invalid timeout error")
+	}
+}
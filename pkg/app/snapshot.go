@@ -0,0 +1,199 @@
+package prometheuslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SnapshotFormatPrometheus = "prometheus"
+	SnapshotFormatJSON       = "json"
+
+	defaultSnapshotInterval = 60 * time.Second
+)
+
+// SnapshotConfig configures periodic metric snapshots, appended to one file
+// per application in Directory: <application>.metrics.prom or
+// <application>.metrics.json, with / in the application name replaced by _. Snapshots are disabled when Directory is empty.
+type SnapshotConfig struct {
+	Directory  string
+	Format     string        // prometheus (default) or json
+	Interval   time.Duration // default 60s
+	MaxSize    int64         // rotate when a file reaches this many bytes; 0 disables
+	MaxAge     time.Duration // rotate when a file has been written for this long; 0 disables
+	MaxBackups int           // rotated files kept per application; 0 keeps all
+}
+
+func (config *SnapshotConfig) setDefaults() {
+	if config.Format == "" {
+		config.Format = SnapshotFormatPrometheus
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultSnapshotInterval
+	}
+}
+
+func (config *SnapshotConfig) check() error {
+	if config.Format != SnapshotFormatPrometheus && config.Format != SnapshotFormatJSON {
+		return fmt.Errorf("snapshots: unknown format %q", config.Format)
+	}
+	return nil
+}
+
+// snapshotWorker appends a snapshot of the application's metrics every
+// interval, and a final one when ctx is done.
+func (application *Application) snapshotWorker(ctx context.Context) {
+	defer application.wg.Done()
+
+	config := application.Config.Snapshot
+	extension := "prom"
+	if config.Format == SnapshotFormatJSON {
+		extension = "json"
+	}
+	writer := &snapshotWriter{
+		config: config,
+		path:   filepath.Join(config.Directory, fmt.Sprintf("%s.metrics.%s", snapshotName.Replace(application.ApplicationName), extension)),
+	}
+	defer writer.close()
+
+	write := func() {
+		if err := writer.write(application.snapshot(config.Format, time.Now())); err != nil {
+			application.logger.Error("unable to write metrics snapshot", "file", writer.path, "error", err)
+		}
+	}
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			write()
+		case <-ctx.Done():
+			write()
+			return
+		}
+	}
+}
+
+// snapshotName replaces the path separators in an application name, such as
+// a discovered container's namespace/pod/container, so every snapshot file
+// is directly in the snapshot directory.
+var snapshotName = strings.NewReplacer("/", "_", `\`, "_")
+
+// snapshot formats the current value of every metric, and the level counts.
+func (application *Application) snapshot(format string, now time.Time) []byte {
+	// named like the live series
+	values := registryValues(application.MetricsRegistry, application.exportedName(), application.Config.Environment)
+	application.exposedLevelValues(application.exportedName(), func(name string, value float64) {
+		values[name] = value
+	})
+
+	if format == SnapshotFormatJSON {
+		data, _ := json.Marshal(struct {
+			Time        time.Time          `json:"time"`
+			Application string             `json:"application"`
+			Environment string             `json:"environment"`
+			Metrics     map[string]float64 `json:"metrics"`
+		}{now, application.ApplicationName, application.Config.Environment, values})
+		return append(data, '\n')
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "# snapshot %s\n", now.Format(time.RFC3339))
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s %g %d\n", name, values[name], now.UnixNano()/int64(time.Millisecond))
+	}
+	return []byte(b.String())
+}
+
+// snapshotWriter appends to a file, rotating it by size and age and
+// removing the oldest rotated files beyond MaxBackups.
+type snapshotWriter struct {
+	config SnapshotConfig
+	path   string
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func (w *snapshotWriter) write(data []byte) error {
+	if w.file != nil && w.shouldRotate(len(data)) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(data)
+	w.size += int64(n)
+	return err
+}
+
+func (w *snapshotWriter) shouldRotate(next int) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.config.MaxSize > 0 && w.size+int64(next) > w.config.MaxSize {
+		return true
+	}
+	return w.config.MaxAge > 0 && time.Since(w.opened) >= w.config.MaxAge
+}
+
+func (w *snapshotWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file, w.size, w.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+func (w *snapshotWriter) rotate() error {
+	w.close()
+	rotated := fmt.Sprintf("%s.%s", w.path, time.Now().Format("20060102T150405.000"))
+	if err := os.Rename(w.path, rotated); err != nil {
+		return err
+	}
+	if w.config.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > w.config.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func (w *snapshotWriter) close() {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+}
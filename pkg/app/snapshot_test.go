@@ -0,0 +1,90 @@
+package prometheuslog
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, format := range []string{SnapshotFormatPrometheus, SnapshotFormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			directory := t.TempDir()
+			app, err := New(Config{Snapshot: SnapshotConfig{Directory: directory, Format: format}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a discovered container's name has slashes
+			application := app.AddApplication(0, "payments/api-1/app", "", 1000, false)
+			application.metricsName = "kubernetes"
+			metrics.GetOrRegisterCounter("errors-total", application.MetricsRegistry).Inc(3)
+			metrics.GetOrRegisterGaugeFloat64("queue-depth", application.MetricsRegistry).Update(2.5)
+			application.levelCounts.inc("warn")
+
+			// the final snapshot is written when ctx is done
+			ctx, cancel := context.WithCancel(context.Background())
+			app.wg.Add(1)
+			go application.snapshotWorker(ctx)
+			cancel()
+			app.wg.Wait()
+
+			entries, _ := os.ReadDir(directory)
+			if len(entries) != 1 || entries[0].IsDir() {
+				t.Fatalf("snapshot directory has %v, want one file", entries)
+			}
+			want := "payments_api-1_app.metrics." + map[string]string{SnapshotFormatPrometheus: "prom", SnapshotFormatJSON: "json"}[format]
+			if entries[0].Name() != want {
+				t.Fatalf("snapshot written to %s, want %s", entries[0].Name(), want)
+			}
+			data, err := os.ReadFile(filepath.Join(directory, want))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			values := map[string]float64{}
+			if format == SnapshotFormatJSON {
+				var snapshot struct {
+					Application string             `json:"application"`
+					Metrics     map[string]float64 `json:"metrics"`
+				}
+				if err := json.Unmarshal(data, &snapshot); err != nil {
+					t.Fatal(err)
+				}
+				if snapshot.Application != "payments/api-1/app" {
+					t.Errorf("application %q", snapshot.Application)
+				}
+				values = snapshot.Metrics
+			} else {
+				parser := expfmt.NewTextParser(model.LegacyValidation)
+				families, err := parser.TextToMetricFamilies(strings.NewReader(string(data)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for name, family := range families {
+					for _, metric := range family.GetMetric() {
+						for _, label := range metric.GetLabel() {
+							name += "{" + label.GetName() + "=\"" + label.GetValue() + "\"}"
+						}
+						values[name] = metric.GetUntyped().GetValue()
+					}
+				}
+			}
+			for name, want := range map[string]float64{
+				"kubernetes_prod_errors_total":                     3,
+				"kubernetes_prod_queue_depth":                      2.5,
+				`kubernetes_prod_log_messages_total{level="warn"}`: 1,
+			} {
+				if got, ok := values[name]; !ok || got != want {
+					t.Errorf("%s = %v (present %v), want %g", name, got, ok, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package prometheuslog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	StatsDFlavorStatsD    = "statsd"
+	StatsDFlavorDogStatsD = "dogstatsd"
+
+	defaultStatsDMaxPacketSize = 1432 // fits an ethernet MTU after IP and UDP headers
+	defaultStatsDInterval      = 100 * time.Millisecond
+	statsdQueueSize            = 4096
+)
+
+// StatsDConfig configures sending every counter increment and gauge update
+// to a StatsD or DogStatsD server over UDP. Updates are batched into packets
+// of up to MaxPacketSize bytes. It is disabled when Address is empty.
+//
+// With the statsd flavor, metrics are named
+// <prefix>.<application>.<environment>.<metric>. With the dogstatsd flavor
+// they are named <prefix>.<metric> and tagged application:<application>,
+// environment:<environment> and Tags.
+type StatsDConfig struct {
+	Address       string            // host:port
+	Flavor        string            // statsd (default) or dogstatsd
+	Prefix        string            // optional
+	Tags          map[string]string // added to every metric, dogstatsd only
+	MaxPacketSize int               // default 1432 bytes
+	Interval      time.Duration     // longest time an update waits for its packet to fill, default 100ms
+}
+
+func (config *StatsDConfig) setDefaults() {
+	if config.Flavor == "" {
+		config.Flavor = StatsDFlavorStatsD
+	}
+	if config.MaxPacketSize <= 0 {
+		config.MaxPacketSize = defaultStatsDMaxPacketSize
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultStatsDInterval
+	}
+}
+
+func (config *StatsDConfig) check() error {
+	if config.Flavor != StatsDFlavorStatsD && config.Flavor != StatsDFlavorDogStatsD {
+		return fmt.Errorf("statsd: unknown flavor %q", config.Flavor)
+	}
+	return nil
+}
+
+// statsdClient queues formatted updates for statsdWorker, which batches
+// them into packets. Updates are dropped rather than slowing down the log
+// workers when the queue is full.
+type statsdClient struct {
+	config StatsDConfig
+	conn   net.Conn
+	queue  chan string
+
+	packetsSent    prometheus.Counter
+	packetsFailed  prometheus.Counter
+	updatesDropped prometheus.Counter
+}
+
+func newStatsdClient(app *App) (*statsdClient, error) {
+	conn, err := net.Dial("udp", app.Config.StatsD.Address)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %v", err)
+	}
+	client := &statsdClient{
+		config: app.Config.StatsD,
+		conn:   conn,
+		queue:  make(chan string, statsdQueueSize),
+		packetsSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheuslog_statsd_packets_sent_total",
+			Help: "Packets sent to the statsd server.",
+		}),
+		packetsFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheuslog_statsd_packets_failed_total",
+			Help: "Packets that could not be sent to the statsd server.",
+		}),
+		updatesDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheuslog_statsd_updates_dropped_total",
+			Help: "Metric updates dropped because the statsd queue was full.",
+		}),
+	}
+	app.collector.MustRegister(client.packetsSent, client.packetsFailed, client.updatesDropped)
+	return client, nil
+}
+
+// statsdWorker sends a packet whenever the next update would not fit, and
+// every interval so that updates are not held back on a quiet log. The
+// lines counted by level since the last interval are added every interval.
+// Queued updates are sent when ctx is done.
+func (app *App) statsdWorker(ctx context.Context, client *statsdClient) {
+	defer app.wg.Done()
+	defer client.conn.Close()
+
+	packet := make([]byte, 0, client.config.MaxPacketSize)
+	flush := func() {
+		if len(packet) == 0 {
+			return
+		}
+		if _, err := client.conn.Write(packet); err != nil {
+			client.packetsFailed.Inc()
+			app.Config.Logger.Debug("statsd: unable to send packet", "address", client.config.Address, "error", err)
+		} else {
+			client.packetsSent.Inc()
+		}
+		packet = packet[:0]
+	}
+	add := func(update string) {
+		if len(packet) > 0 && len(packet)+1+len(update) > client.config.MaxPacketSize {
+			flush()
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, update...)
+	}
+	sent := map[*Application]map[string]float64{}
+	addLevels := func() {
+		app.Lock()
+		applications := app.Applications
+		app.Unlock()
+		counts := make(map[*Application]map[string]float64, len(applications))
+		for _, application := range applications {
+			registry, ok := application.statsdRegistry.(*statsdRegistry)
+			if !ok {
+				continue
+			}
+			levels := sent[application]
+			if levels == nil {
+				levels = map[string]float64{}
+			}
+			for _, update := range registry.levelUpdates(application, levels) {
+				add(update)
+			}
+			counts[application] = levels
+		}
+		sent = counts
+	}
+
+	ticker := time.NewTicker(client.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case update := <-client.queue:
+			add(update)
+		case <-ticker.C:
+			addLevels()
+			flush()
+		case <-ctx.Done():
+			addLevels()
+			for {
+				select {
+				case update := <-client.queue:
+					add(update)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// statsdName replaces the characters that are part of the statsd protocol.
+var statsdName = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_")
+
+// newStatsdRegistry wraps an application's registry so that counters and
+// gauges obtained from it also send their updates to the statsd server.
+func (client *statsdClient) newStatsdRegistry(registry metrics.Registry, applicationName string, environment string) metrics.Registry {
+	prefix := client.config.Prefix
+	if client.config.Flavor == StatsDFlavorStatsD {
+		prefix = strings.Join([]string{prefix, flattenKey(applicationName), flattenKey(environment)}, ".")
+	}
+	prefix = strings.TrimPrefix(prefix, ".")
+
+	var tags string
+	if client.config.Flavor == StatsDFlavorDogStatsD {
+		tag := func(name, value string) string {
+			return statsdName.Replace(name) + ":" + statsdName.Replace(value)
+		}
+		list := []string{tag("application", applicationName), tag("environment", environment)}
+		for name, value := range client.config.Tags {
+			list = append(list, tag(name, value))
+		}
+		sort.Strings(list[2:])
+		tags = "|#" + strings.Join(list, ",")
+	}
+	return &statsdRegistry{Registry: registry, client: client, prefix: prefix, tags: tags}
+}
+
+type statsdRegistry struct {
+	metrics.Registry
+	client *statsdClient
+	prefix string
+	tags   string
+}
+
+func (registry *statsdRegistry) GetOrRegister(name string, i interface{}) interface{} {
+	metric := registry.Registry.GetOrRegister(name, i)
+	stat := &statsdStat{registry: registry, name: registry.statName(name)}
+	switch m := metric.(type) {
+	case metrics.Counter:
+		return statsdCounter{m, stat}
+	case metrics.Gauge:
+		return statsdGauge{m, stat}
+	case metrics.GaugeFloat64:
+		return statsdGaugeFloat64{m, stat}
+	}
+	return metric
+}
+
+func (registry *statsdRegistry) statName(name string) string {
+	name = statsdName.Replace(flattenKey(name))
+	if registry.prefix != "" {
+		name = registry.prefix + "." + name
+	}
+	return name
+}
+
+// levelUpdates returns counter updates for the lines an application counted
+// by level since the counts in sent, which it updates. The level ends the
+// name with the statsd flavor, and is a tag with the dogstatsd flavor.
+func (registry *statsdRegistry) levelUpdates(application *Application, sent map[string]float64) []string {
+	var updates []string
+	application.levelValues(func(name string, level string, value float64) {
+		if increment := value - sent[level]; increment > 0 {
+			name, tags := registry.statName(name), registry.tags
+			if registry.client.config.Flavor == StatsDFlavorDogStatsD {
+				tags += ",level:" + level
+			} else {
+				name += "." + level
+			}
+			updates = append(updates, name+":"+strconv.FormatFloat(increment, 'f', -1, 64)+"|c"+tags)
+		}
+		sent[level] = value
+	})
+	return updates
+}
+
+type statsdStat struct {
+	registry *statsdRegistry
+	name     string
+}
+
+func (stat *statsdStat) send(value string, statType string) {
+	update := stat.name + ":" + value + "|" + statType + stat.registry.tags
+	select {
+	case stat.registry.client.queue <- update:
+	default:
+		stat.registry.client.updatesDropped.Inc()
+	}
+}
+
+// gauge sends a gauge value. A signed value is a relative change in
+// statsd, so a negative value is sent as a reset to zero followed by the
+// decrement, in a single update so both land in the same packet.
+func (stat *statsdStat) gauge(value string) {
+	if strings.HasPrefix(value, "-") {
+		value = "0|g" + stat.registry.tags + "\n" + stat.name + ":" + value
+	}
+	stat.send(value, "g")
+}
+
+type statsdCounter struct {
+	metrics.Counter
+	stat *statsdStat
+}
+
+func (c statsdCounter) Inc(i int64) {
+	c.Counter.Inc(i)
+	c.stat.send(strconv.FormatInt(i, 10), "c")
+}
+
+func (c statsdCounter) Dec(i int64) {
+	c.Counter.Dec(i)
+	c.stat.send(strconv.FormatInt(-i, 10), "c")
+}
+
+type statsdGauge struct {
+	metrics.Gauge
+	stat *statsdStat
+}
+
+func (g statsdGauge) Update(v int64) {
+	g.Gauge.Update(v)
+	g.stat.gauge(strconv.FormatInt(v, 10))
+}
+
+type statsdGaugeFloat64 struct {
+	metrics.GaugeFloat64
+	stat *statsdStat
+}
+
+func (g statsdGaugeFloat64) Update(v float64) {
+	g.GaugeFloat64.Update(v)
+	g.stat.gauge(strconv.FormatFloat(v, 'f', -1, 64))
+}
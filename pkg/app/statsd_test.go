@@ -0,0 +1,209 @@
+package prometheuslog
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// statsdServer listens for statsd packets on a local UDP port.
+func statsdServer(t *testing.T) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func newTestStatsdClient(t *testing.T, config StatsDConfig) (*App, *statsdClient) {
+	t.Helper()
+	app := NewApp()
+	app.Config.StatsD = config
+	app.Config.StatsD.setDefaults()
+	client, err := newStatsdClient(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app, client
+}
+
+func TestStatsdUpdates(t *testing.T) {
+	server := statsdServer(t)
+	tests := []struct {
+		name   string
+		config StatsDConfig
+		update func(registry metrics.Registry)
+		want   []string
+	}{
+		{
+			name:   "counter",
+			update: func(registry metrics.Registry) { metrics.GetOrRegisterCounter("errors-total", registry).Inc(2) },
+			want:   []string{"myapp.prod.errors_total:2|c"},
+		},
+		{
+			name:   "gauge",
+			update: func(registry metrics.Registry) { metrics.GetOrRegisterGauge("queue-depth", registry).Update(5) },
+			want:   []string{"myapp.prod.queue_depth:5|g"},
+		},
+		{
+			// a signed gauge is a relative change, so it is reset first
+			name:   "negative gauge",
+			update: func(registry metrics.Registry) { metrics.GetOrRegisterGauge("balance", registry).Update(-5) },
+			want:   []string{"myapp.prod.balance:0|g\nmyapp.prod.balance:-5|g"},
+		},
+		{
+			name:   "negative float gauge",
+			update: func(registry metrics.Registry) { metrics.GetOrRegisterGaugeFloat64("drift", registry).Update(-0.25) },
+			want:   []string{"myapp.prod.drift:0|g\nmyapp.prod.drift:-0.25|g"},
+		},
+		{
+			name:   "prefix",
+			config: StatsDConfig{Prefix: "logs"},
+			update: func(registry metrics.Registry) { metrics.GetOrRegisterCounter("errors-total", registry).Inc(1) },
+			want:   []string{"logs.myapp.prod.errors_total:1|c"},
+		},
+		{
+			// extra tags are sorted, and protocol characters replaced
+			name:   "dogstatsd tags",
+			config: StatsDConfig{Flavor: StatsDFlavorDogStatsD, Prefix: "logs", Tags: map[string]string{"region": "eu-west", "a:b": "c|d"}},
+			update: func(registry metrics.Registry) { metrics.GetOrRegisterGauge("balance", registry).Update(-1) },
+			want: []string{"logs.balance:0|g|#application:myapp,environment:prod,a_b:c_d,region:eu-west\n" +
+				"logs.balance:-1|g|#application:myapp,environment:prod,a_b:c_d,region:eu-west"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.config.Address = server.LocalAddr().String()
+			_, client := newTestStatsdClient(t, test.config)
+			defer client.conn.Close()
+			test.update(client.newStatsdRegistry(metrics.NewRegistry(), "myapp", "prod"))
+
+			var got []string
+			for len(client.queue) > 0 {
+				got = append(got, <-client.queue)
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStatsdPackets(t *testing.T) {
+	server := statsdServer(t)
+	app, client := newTestStatsdClient(t, StatsDConfig{Address: server.LocalAddr().String(), MaxPacketSize: 64, Interval: time.Hour})
+	registry := client.newStatsdRegistry(metrics.NewRegistry(), "myapp", "prod")
+	var want []string
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		metrics.GetOrRegisterCounter(name, registry).Inc(1)
+		want = append(want, "myapp.prod."+name+":1|c")
+	}
+
+	// queued updates are sent when ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	app.wg.Add(1)
+	go app.statsdWorker(ctx, client)
+	cancel()
+	app.wg.Wait()
+
+	var packets []string
+	buffer := make([]byte, 2048)
+	for received := 0; received < len(want); {
+		server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := server.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("received %q: %v", packets, err)
+		}
+		packet := string(buffer[:n])
+		if n > 64 {
+			t.Errorf("packet of %d bytes, above the maximum of 64: %q", n, packet)
+		}
+		packets = append(packets, packet)
+		received += len(strings.Split(packet, "\n"))
+	}
+	// each update is 16 bytes, so three fit in a packet with the newlines
+	if len(packets) != 3 {
+		t.Errorf("%d packets, want 3: %q", len(packets), packets)
+	}
+	if got := strings.Join(packets, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if sent := counterValue(t, client.packetsSent); sent != float64(len(packets)) {
+		t.Errorf("prometheuslog_statsd_packets_sent_total = %g, want %d", sent, len(packets))
+	}
+}
+
+func TestStatsdQueueFull(t *testing.T) {
+	server := statsdServer(t)
+	_, client := newTestStatsdClient(t, StatsDConfig{Address: server.LocalAddr().String()})
+	defer client.conn.Close()
+	counter := metrics.GetOrRegisterCounter("errors-total", client.newStatsdRegistry(metrics.NewRegistry(), "myapp", "prod"))
+	for i := 0; i < statsdQueueSize+10; i++ {
+		counter.Inc(1)
+	}
+	if dropped := counterValue(t, client.updatesDropped); dropped != 10 {
+		t.Errorf("prometheuslog_statsd_updates_dropped_total = %g, want 10", dropped)
+	}
+	if counter.Count() != statsdQueueSize+10 {
+		t.Errorf("counter = %d, want every increment counted", counter.Count())
+	}
+}
+
+func TestStatsdLinesRead(t *testing.T) {
+	server := statsdServer(t)
+	logPath := filepath.Join(t.TempDir(), "myapp.log")
+	if err := os.WriteFile(logPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	app, err := New(Config{
+		Watch:        WatchPoll,
+		PollInterval: 10 * time.Millisecond,
+		StatsD:       StatsDConfig{Address: server.LocalAddr().String()},
+		Rules:        []Rule{{Name: "errors", Contains: "ERROR", Metric: "errors-total"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	application := app.AddApplication(0, "myapp", logPath, 1000, false)
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(logPath, []byte("INFO started\nERROR failed\nINFO done\n"), 0644)
+	read := metrics.GetOrRegisterCounter("apm-log-read-rate", application.MetricsRegistry)
+	for deadline := time.Now().Add(5 * time.Second); read.Count() < 3; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the log lines were not read")
+		}
+	}
+	app.Stop(context.Background())
+
+	// only the rule's counter is sent, not the count of lines read
+	var updates []string
+	buffer := make([]byte, 2048)
+	for {
+		server.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := server.ReadFrom(buffer)
+		if err != nil {
+			break
+		}
+		updates = append(updates, strings.Split(string(buffer[:n]), "\n")...)
+	}
+	for _, update := range updates {
+		if strings.Contains(update, "apm_log_read_rate") {
+			t.Errorf("lines read were sent: %q", updates)
+			break
+		}
+	}
+	if !slices.Contains(updates, "myapp.prod.errors_total:1|c") {
+		t.Errorf("got %q, want the errors_total increment", updates)
+	}
+}
@@ -0,0 +1,484 @@
+package prometheuslog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SyslogPathPrefix starts the log path of an application that receives
+// messages from the syslog listeners. The rest of the path selects the
+// messages, matching the hostname, app_name and facility fields against
+// glob patterns; an application with an empty selector receives every
+// message not selected by an earlier application:
+//
+//	firewall,syslog:hostname=fw-*&facility=local0
+//	sshd,syslog:app_name=sshd
+//	everything-else,syslog:
+const SyslogPathPrefix = "syslog:"
+
+const (
+	syslogQueueSize     = 1024
+	syslogMaxPacketSize = 64 * 1024
+)
+
+// syslogRouteFields are the fields an application may select messages by.
+var syslogRouteFields = map[string]bool{"hostname": true, "app_name": true, "facility": true}
+
+// SyslogConfig configures the syslog listeners. Messages in both RFC 3164
+// and RFC 5424 format are accepted, newline delimited or octet counted on
+// stream sockets. Each message becomes one line with the fields facility,
+// severity, hostname, app_name, procid and msgid when present.
+type SyslogConfig struct {
+	Listen []string // udp://:514, tcp://:514 or unix:///path/to/socket (a datagram socket like /dev/log)
+}
+
+func (config *SyslogConfig) check() error {
+	for _, address := range config.Listen {
+		if _, _, err := parseSyslogAddress(address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseSyslogAddress(address string) (network string, addr string, err error) {
+	parts := strings.SplitN(address, "://", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("syslog: invalid listen address %q, expected udp://, tcp:// or unix://", address)
+	}
+	switch parts[0] {
+	case "udp", "tcp":
+		return parts[0], parts[1], nil
+	case "unix":
+		return "unixgram", parts[1], nil
+	}
+	return "", "", fmt.Errorf("syslog: unknown network %q in %q", parts[0], address)
+}
+
+// parseSyslogRoute parses the selector of a SyslogPathPrefix log path.
+func parseSyslogRoute(logPath string) (map[string]string, error) {
+	query, err := url.ParseQuery(strings.TrimPrefix(logPath, SyslogPathPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("syslog: invalid selector %q: %v", logPath, err)
+	}
+	match := map[string]string{}
+	for name, values := range query {
+		if !syslogRouteFields[name] {
+			return nil, fmt.Errorf("syslog: unknown field %q in %q, expected hostname, app_name or facility", name, logPath)
+		}
+		if _, err := path.Match(values[0], ""); err != nil {
+			return nil, fmt.Errorf("syslog: invalid pattern %q in %q", values[0], logPath)
+		}
+		match[name] = values[0]
+	}
+	return match, nil
+}
+
+// syslogReceiver reads messages from every listener and delivers each one
+// to the first application that selects it.
+type syslogReceiver struct {
+	app *App
+
+	mu      sync.Mutex
+	routes  []syslogRoute
+	closers map[io.Closer]bool // listeners and connections
+	closed  bool
+
+	messagesReceived prometheus.Counter
+	messagesUnrouted prometheus.Counter
+	messagesDropped  prometheus.Counter
+}
+
+type syslogRoute struct {
+	match map[string]string
+	input *syslogInput
+}
+
+// newSyslogReceiver binds every listener and serves them until ctx is done.
+func newSyslogReceiver(ctx context.Context, app *App) (*syslogReceiver, error) {
+	receiver := &syslogReceiver{
+		app:     app,
+		closers: map[io.Closer]bool{},
+		messagesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheuslog_syslog_messages_received_total",
+			Help: "Messages received by the syslog listeners.",
+		}),
+		messagesUnrouted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheuslog_syslog_messages_unrouted_total",
+			Help: "Syslog messages not selected by any application.",
+		}),
+		messagesDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheuslog_syslog_messages_dropped_total",
+			Help: "Syslog messages dropped because their application was not keeping up.",
+		}),
+	}
+
+	for _, address := range app.Config.Syslog.Listen {
+		network, addr, err := parseSyslogAddress(address)
+		if err == nil {
+			err = receiver.listen(network, addr)
+		}
+		if err != nil {
+			receiver.close()
+			return nil, err
+		}
+	}
+	app.collector.MustRegister(receiver.messagesReceived, receiver.messagesUnrouted, receiver.messagesDropped)
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		<-ctx.Done()
+		receiver.close()
+	}()
+	return receiver, nil
+}
+
+func (receiver *syslogReceiver) listen(network string, addr string) error {
+	logger := receiver.app.Config.Logger
+	if network == "tcp" {
+		listener, err := net.Listen(network, addr)
+		if err != nil {
+			return fmt.Errorf("syslog: %v", err)
+		}
+		receiver.addCloser(listener)
+		logger.Info("listening for syslog messages", "network", network, "address", addr)
+		go receiver.acceptStreams(listener)
+		return nil
+	}
+
+	if network == "unixgram" {
+		if info, err := os.Stat(addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(addr)
+		}
+	}
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return fmt.Errorf("syslog: %v", err)
+	}
+	receiver.addCloser(conn)
+	logger.Info("listening for syslog messages", "network", network, "address", addr)
+	go receiver.readPackets(conn)
+	return nil
+}
+
+// addCloser records a listener or connection to close on shutdown. It
+// returns false, after closing it, if the receiver is already closed.
+func (receiver *syslogReceiver) addCloser(closer io.Closer) bool {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if receiver.closed {
+		closer.Close()
+		return false
+	}
+	receiver.closers[closer] = true
+	return true
+}
+
+func (receiver *syslogReceiver) removeCloser(closer io.Closer) {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	delete(receiver.closers, closer)
+}
+
+func (receiver *syslogReceiver) close() {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	receiver.closed = true
+	for closer := range receiver.closers {
+		closer.Close()
+		if conn, ok := closer.(*net.UnixConn); ok {
+			os.Remove(conn.LocalAddr().String())
+		}
+	}
+	receiver.closers = nil
+}
+
+// addRoute returns the input of an application selecting messages with a
+// SyslogPathPrefix log path.
+func (receiver *syslogReceiver) addRoute(logPath string) (Input, error) {
+	match, err := parseSyslogRoute(logPath)
+	if err != nil {
+		return nil, err
+	}
+	input := &syslogInput{lines: make(chan Line, syslogQueueSize), done: make(chan struct{})}
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	receiver.routes = append(receiver.routes, syslogRoute{match, input})
+	return input, nil
+}
+
+func (receiver *syslogReceiver) readPackets(conn net.PacketConn) {
+	buf := make([]byte, syslogMaxPacketSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !isClosedError(err) {
+				receiver.app.Config.Logger.Error("syslog: read failed", "error", err)
+			}
+			return
+		}
+		receiver.deliver(buf[:n], from)
+	}
+}
+
+func (receiver *syslogReceiver) acceptStreams(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !isClosedError(err) {
+				receiver.app.Config.Logger.Error("syslog: accept failed", "error", err)
+			}
+			return
+		}
+		if !receiver.addCloser(conn) {
+			return
+		}
+		go receiver.readStream(conn)
+	}
+}
+
+// readStream reads octet counted ("<length> <message>") or newline
+// delimited messages, as described in RFC 6587.
+func (receiver *syslogReceiver) readStream(conn net.Conn) {
+	defer receiver.removeCloser(conn)
+	defer conn.Close()
+	reader := bufio.NewReaderSize(conn, syslogMaxPacketSize)
+	for {
+		first, err := reader.Peek(1)
+		if err != nil {
+			return
+		}
+		var message []byte
+		if first[0] >= '0' && first[0] <= '9' {
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(length))
+			if err != nil || n > maxLineLength {
+				receiver.app.Config.Logger.Warn("syslog: invalid message length, closing connection", "remote", conn.RemoteAddr().String(), "length", length)
+				return
+			}
+			message = make([]byte, n)
+			if _, err := io.ReadFull(reader, message); err != nil {
+				return
+			}
+		} else {
+			message, err = reader.ReadBytes('\n')
+			if err != nil && len(message) == 0 {
+				return
+			}
+		}
+		receiver.deliver(message, conn.RemoteAddr())
+	}
+}
+
+func (receiver *syslogReceiver) deliver(data []byte, from net.Addr) {
+	receiver.messagesReceived.Inc()
+	line := parseSyslog(data, time.Now())
+	if line.Fields["hostname"] == "" && from != nil {
+		if host, _, err := net.SplitHostPort(from.String()); err == nil {
+			line.Fields["hostname"] = host
+		}
+	}
+
+	receiver.mu.Lock()
+	routes := receiver.routes
+	receiver.mu.Unlock()
+	for _, route := range routes {
+		if !route.matches(line.Fields) {
+			continue
+		}
+		select {
+		case route.input.lines <- line:
+		case <-route.input.done:
+		default:
+			receiver.messagesDropped.Inc()
+		}
+		return
+	}
+	receiver.messagesUnrouted.Inc()
+}
+
+func (route syslogRoute) matches(fields Fields) bool {
+	for name, pattern := range route.match {
+		if matched, _ := path.Match(pattern, fields[name]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func isClosedError(err error) bool {
+	return errors.Is(err, net.ErrClosed)
+}
+
+// syslogInput is the Input of an application fed by the syslog receiver.
+// Its lines are never closed; it ends with the App.
+type syslogInput struct {
+	lines chan Line
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (input *syslogInput) Lines() <-chan Line { return input.lines }
+func (input *syslogInput) Err() error         { return nil }
+func (input *syslogInput) Close()             { input.once.Do(func() { close(input.done) }) }
+
+var (
+	syslogFacilities = []string{
+		"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+		"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+		"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+	}
+	syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+)
+
+// defaultSyslogPriority is user.notice, the priority RFC 3164 assigns to
+// messages without one.
+const defaultSyslogPriority = 13
+
+// parseSyslog parses an RFC 5424 or RFC 3164 message. Messages in neither
+// format are kept whole, with the default priority.
+func parseSyslog(data []byte, now time.Time) Line {
+	message := strings.TrimRight(string(data), "\r\n\x00")
+	priority := defaultSyslogPriority
+	if strings.HasPrefix(message, "<") {
+		if end := strings.IndexByte(message, '>'); end > 1 && end <= 4 {
+			if p, err := strconv.Atoi(message[1:end]); err == nil && p < len(syslogFacilities)*8 {
+				priority = p
+				message = message[end+1:]
+			}
+		}
+	}
+
+	line := Line{Fields: Fields{
+		"facility": syslogFacilities[priority/8],
+		"severity": syslogSeverities[priority%8],
+	}}
+	if strings.HasPrefix(message, "1 ") {
+		parseRFC5424(message[2:], &line)
+	} else {
+		parseRFC3164(message, &line, now)
+	}
+	return line
+}
+
+// parseRFC5424 parses the part of an RFC 5424 message after the version:
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG].
+func parseRFC5424(message string, line *Line) {
+	parts := strings.SplitN(message, " ", 6)
+	for len(parts) < 6 {
+		parts = append(parts, "-")
+	}
+	if timestamp, err := time.Parse(time.RFC3339Nano, parts[0]); err == nil {
+		line.Time = timestamp
+	}
+	for i, name := range []string{"hostname", "app_name", "procid", "msgid"} {
+		if parts[i+1] != "-" {
+			line.Fields[name] = parts[i+1]
+		}
+	}
+
+	rest := parts[5]
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else {
+		rest = rest[structuredDataLength(rest):]
+	}
+	rest = strings.TrimPrefix(rest, " ")
+	line.Text = strings.TrimPrefix(rest, "\ufeff") // UTF-8 byte order mark
+}
+
+// structuredDataLength returns the length of the structured data elements
+// at the start of s: [id param="value" ...], where values may contain
+// escaped quotes and brackets.
+func structuredDataLength(s string) int {
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		end := structuredDataElementEnd(s, i+1)
+		if end < 0 {
+			return len(s)
+		}
+		i = end + 1
+	}
+	return i
+}
+
+// structuredDataElementEnd returns the index of the ] closing the element
+// that starts before start, or -1.
+func structuredDataElementEnd(s string, start int) int {
+	quoted := false
+	for i := start; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseRFC3164 parses a BSD syslog message: TIMESTAMP HOSTNAME TAG[PID]: MSG.
+// The timestamp has no year; it is taken to be in the last twelve months.
+// Messages from a local socket usually have no hostname, and there is no
+// hostname without a timestamp.
+func parseRFC3164(message string, line *Line, now time.Time) {
+	if len(message) >= len(time.Stamp) {
+		if timestamp, err := time.ParseInLocation(time.Stamp, message[:len(time.Stamp)], time.Local); err == nil {
+			timestamp = timestamp.AddDate(now.Year(), 0, 0)
+			if timestamp.After(now.Add(24 * time.Hour)) {
+				timestamp = timestamp.AddDate(-1, 0, 0)
+			}
+			line.Time = timestamp
+			message = strings.TrimPrefix(message[len(time.Stamp):], " ")
+		}
+	}
+	if line.Time.IsZero() {
+		if i := strings.IndexByte(message, ' '); i > 0 {
+			if timestamp, err := time.Parse(time.RFC3339Nano, message[:i]); err == nil {
+				line.Time = timestamp
+				message = message[i+1:]
+			}
+		}
+	}
+
+	if i := strings.IndexByte(message, ' '); i > 0 && !line.Time.IsZero() && !strings.ContainsAny(message[:i], "[:") {
+		line.Fields["hostname"] = message[:i]
+		message = message[i+1:]
+	}
+
+	if i := strings.IndexAny(message, "[: "); i > 0 && message[i] != ' ' {
+		tag, rest, procid := message[:i], message[i:], ""
+		if rest[0] == '[' {
+			if end := strings.IndexByte(rest, ']'); end > 0 {
+				procid, rest = rest[1:end], rest[end+1:]
+			}
+		}
+		if strings.HasPrefix(rest, ":") {
+			line.Fields["app_name"] = tag
+			if procid != "" {
+				line.Fields["procid"] = procid
+			}
+			message = strings.TrimPrefix(rest[1:], " ")
+		}
+	}
+	line.Text = message
+}
@@ -0,0 +1,306 @@
+package prometheuslog
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name   string
+		data   string
+		text   string
+		fields Fields
+		time   time.Time
+	}{
+		{
+			name:   "rfc 3164",
+			data:   "<34>Feb 28 22:14:15 mymachine su: 'su root' failed for lonvick\n",
+			text:   "'su root' failed for lonvick",
+			fields: Fields{"facility": "auth", "severity": "crit", "hostname": "mymachine", "app_name": "su"},
+			time:   time.Date(2026, 2, 28, 22, 14, 15, 0, time.Local),
+		},
+		{
+			// from later in the year than now, so last year's
+			name:   "rfc 3164 from last year",
+			data:   "<34>Oct 11 22:14:15 mymachine su: failed",
+			text:   "failed",
+			fields: Fields{"facility": "auth", "severity": "crit", "hostname": "mymachine", "app_name": "su"},
+			time:   time.Date(2025, 10, 11, 22, 14, 15, 0, time.Local),
+		},
+		{
+			// as sent to a local socket
+			name:   "rfc 3164 without hostname",
+			data:   "<86>Feb 28 10:00:00 sshd[42]: Accepted publickey for root",
+			text:   "Accepted publickey for root",
+			fields: Fields{"facility": "authpriv", "severity": "info", "app_name": "sshd", "procid": "42"},
+			time:   time.Date(2026, 2, 28, 10, 0, 0, 0, time.Local),
+		},
+		{
+			name:   "rfc 3164 with year",
+			data:   "<165>2026-02-28T10:00:00Z host1 app: hello",
+			text:   "hello",
+			fields: Fields{"facility": "local4", "severity": "notice", "hostname": "host1", "app_name": "app"},
+			time:   time.Date(2026, 2, 28, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "rfc 3164 without timestamp or hostname",
+			data:   "<14>app: hello world",
+			text:   "hello world",
+			fields: Fields{"facility": "user", "severity": "info", "app_name": "app"},
+		},
+		{
+			name:   "rfc 5424 with structured data",
+			data:   `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"] ` + "\ufeff" + "An application event log entry",
+			text:   "An application event log entry",
+			fields: Fields{"facility": "local4", "severity": "notice", "hostname": "mymachine.example.com", "app_name": "evntslog", "msgid": "ID47"},
+			time:   time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC),
+		},
+		{
+			name:   "rfc 5424 with escapes in structured data",
+			data:   `<13>1 2026-02-28T10:00:00Z host app 1234 - [a@1 x="[\"q\"\]"][b@1 y="z"] message [not data]`,
+			text:   "message [not data]",
+			fields: Fields{"facility": "user", "severity": "notice", "hostname": "host", "app_name": "app", "procid": "1234"},
+			time:   time.Date(2026, 2, 28, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "rfc 5424 with nil values",
+			data:   "<15>1 - - - - - - hello",
+			text:   "hello",
+			fields: Fields{"facility": "user", "severity": "debug"},
+		},
+		{
+			name:   "rfc 5424 without message",
+			data:   "<15>1 - - - - -",
+			fields: Fields{"facility": "user", "severity": "debug"},
+		},
+		{
+			name:   "pri out of range",
+			data:   "<999>hello",
+			text:   "<999>hello",
+			fields: Fields{"facility": "user", "severity": "notice"},
+		},
+		{
+			name:   "pri not a number",
+			data:   "<abc>hello",
+			text:   "<abc>hello",
+			fields: Fields{"facility": "user", "severity": "notice"},
+		},
+		{
+			name:   "pri not closed",
+			data:   "<13hello",
+			text:   "<13hello",
+			fields: Fields{"facility": "user", "severity": "notice"},
+		},
+		{
+			name:   "no pri",
+			data:   "just a message",
+			text:   "just a message",
+			fields: Fields{"facility": "user", "severity": "notice"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line := parseSyslog([]byte(test.data), now)
+			if line.Text != test.text {
+				t.Errorf("text %q, want %q", line.Text, test.text)
+			}
+			if !maps.Equal(line.Fields, test.fields) {
+				t.Errorf("fields %v, want %v", line.Fields, test.fields)
+			}
+			if !line.Time.Equal(test.time) {
+				t.Errorf("time %v, want %v", line.Time, test.time)
+			}
+		})
+	}
+}
+
+func TestSyslogRoutes(t *testing.T) {
+	receiver := syslogTestReceiver(t, nil)
+	routes := map[string]string{
+		"sshd":     "syslog:app_name=sshd",
+		"firewall": "syslog:hostname=fw-*&facility=local0",
+		"other":    "syslog:",
+	}
+	inputs := map[string]Input{}
+	// in order, as the catch all must come last
+	for _, name := range []string{"sshd", "firewall", "other"} {
+		input, err := receiver.addRoute(routes[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs[name] = input
+	}
+
+	tests := []struct {
+		data  string
+		input string
+	}{
+		{data: "<86>Feb 28 10:00:00 web-1 sshd[42]: Accepted publickey", input: "sshd"},
+		{data: "<86>1 2026-02-28T10:00:00Z web-1 sshd 42 - - Accepted publickey", input: "sshd"},
+		{data: "<134>Feb 28 10:00:00 fw-01 kernel: DROP", input: "firewall"},
+		// the facility doesn't match
+		{data: "<6>Feb 28 10:00:00 fw-01 kernel: DROP", input: "other"},
+		{data: "<14>Feb 28 10:00:00 web-1 cron: job done", input: "other"},
+	}
+	for _, test := range tests {
+		receiver.deliver([]byte(test.data), nil)
+		for name, input := range inputs {
+			select {
+			case line := <-input.Lines():
+				if name != test.input {
+					t.Errorf("%q was routed to %s, want %s", test.data, name, test.input)
+				} else if line.Text == "" {
+					t.Errorf("%q was routed without its text", test.data)
+				}
+			default:
+				if name == test.input {
+					t.Errorf("%q was not routed to %s", test.data, name)
+				}
+			}
+		}
+	}
+	if got := counterValue(t, receiver.messagesUnrouted); got != 0 {
+		t.Errorf("%g messages unrouted, want 0", got)
+	}
+}
+
+func TestSyslogUnrouted(t *testing.T) {
+	receiver := syslogTestReceiver(t, nil)
+	if _, err := receiver.addRoute("syslog:app_name=sshd"); err != nil {
+		t.Fatal(err)
+	}
+	receiver.deliver([]byte("<14>Feb 28 10:00:00 web-1 cron: job done"), nil)
+	if got := counterValue(t, receiver.messagesUnrouted); got != 1 {
+		t.Errorf("%g messages unrouted, want 1", got)
+	}
+}
+
+func TestSyslogStreams(t *testing.T) {
+	octetCounted := func(messages ...string) string {
+		var b strings.Builder
+		for _, message := range messages {
+			fmt.Fprintf(&b, "%d %s", len(message), message)
+		}
+		return b.String()
+	}
+	tests := []struct {
+		name   string
+		stream string
+		texts  []string
+	}{
+		{
+			name:   "octet counted",
+			stream: octetCounted("<14>app: first", "<14>app: second\nline", "<14>app: third"),
+			texts:  []string{"first", "second\nline", "third"},
+		},
+		{
+			// the last message ends with the connection
+			name:   "newline delimited",
+			stream: "<14>app: first\n<14>app: second\r\n<14>app: third",
+			texts:  []string{"first", "second", "third"},
+		},
+		{
+			name:   "mixed",
+			stream: "<14>app: first\n" + octetCounted("<14>app: second") + "<14>app: third\n",
+			texts:  []string{"first", "second", "third"},
+		},
+		{
+			// the connection is closed at the invalid length
+			name:   "invalid length",
+			stream: "<14>app: first\n99999999 <14>app: second\n<14>app: third\n",
+			texts:  []string{"first"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := syslogTestReceiver(t, []string{"tcp://127.0.0.1:0"})
+			input, err := receiver.addRoute("syslog:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := net.Dial("tcp", syslogTestAddress(t, receiver))
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Write([]byte(test.stream))
+			conn.Close()
+			expectLines(t, input, test.texts...)
+			// nothing more, once the connection has been read to its end
+			time.Sleep(50 * time.Millisecond)
+			select {
+			case line := <-input.Lines():
+				t.Errorf("read %q, want nothing more", line.Text)
+			default:
+			}
+		})
+	}
+}
+
+func TestSyslogPackets(t *testing.T) {
+	receiver := syslogTestReceiver(t, []string{"udp://127.0.0.1:0"})
+	input, err := receiver.addRoute("syslog:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("udp", syslogTestAddress(t, receiver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// one message a packet, with or without a trailing newline
+	conn.Write([]byte("<14>app: first\n"))
+	conn.Write([]byte("<14>1 - - app - - - second"))
+	select {
+	case line := <-input.Lines():
+		// the sender's address, as the message has no hostname
+		if line.Text != "first" || line.Fields["hostname"] != "127.0.0.1" {
+			t.Errorf("read %q from %q, want first from 127.0.0.1", line.Text, line.Fields["hostname"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("first was not read")
+	}
+	expectLines(t, input, "second")
+}
+
+// syslogTestReceiver returns a receiver listening on the listen addresses,
+// closed at the end of the test.
+func syslogTestReceiver(t *testing.T, listen []string) *syslogReceiver {
+	t.Helper()
+	app := NewApp()
+	app.Config.Syslog.Listen = listen
+	ctx, cancel := context.WithCancel(context.Background())
+	receiver, err := newSyslogReceiver(ctx, app)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		app.wg.Wait()
+	})
+	return receiver
+}
+
+// syslogTestAddress returns the address of the receiver's only listener.
+func syslogTestAddress(t *testing.T, receiver *syslogReceiver) string {
+	t.Helper()
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	for closer := range receiver.closers {
+		switch listener := closer.(type) {
+		case net.Listener:
+			return listener.Addr().String()
+		case net.PacketConn:
+			return listener.LocalAddr().String()
+		}
+	}
+	t.Fatal("the receiver isn't listening")
+	return ""
+}
@@ -0,0 +1,40 @@
+package prometheuslog
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WriteTextfile atomically replaces path with the metrics of every
+// application in the prometheus text format, for the node_exporter textfile
+// collector. path should end in .prom.
+func (app *App) WriteTextfile(path string) error {
+	return prometheus.WriteToTextfile(path, app.gatherer())
+}
+
+// textfileWorker rewrites Config.Textfile every flush interval, and once more
+// with freshly flushed values when ctx is done.
+func (app *App) textfileWorker(ctx context.Context) {
+	defer app.wg.Done()
+
+	write := func() {
+		if err := app.WriteTextfile(app.Config.Textfile); err != nil {
+			app.Config.Logger.Error("unable to write textfile", "file", app.Config.Textfile, "error", err)
+		}
+	}
+
+	ticker := time.NewTicker(app.Config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			write()
+		case <-ctx.Done():
+			app.flushAll()
+			write()
+			return
+		}
+	}
+}
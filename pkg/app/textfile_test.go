@@ -0,0 +1,62 @@
+package prometheuslog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestWriteTextfile(t *testing.T) {
+	app, err := New(Config{IngestTokens: []string{"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// with labels, metrics are read from the registry when gathered
+	application := app.AddApplication(0, "myapp", IngestPath, 1000, false)
+	application.Labels = map[string]string{"team": "payments"}
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Stop(context.Background())
+	metrics.GetOrRegisterCounter("errors-total", application.MetricsRegistry).Inc(3)
+
+	path := filepath.Join(t.TempDir(), "prometheuslog.prom")
+	if err := app.WriteTextfile(path); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	parser := expfmt.NewTextParser(model.LegacyValidation)
+	families, err := parser.TextToMetricFamilies(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	family := families["myapp_prod_errors_total"]
+	if family == nil || len(family.GetMetric()) != 1 {
+		t.Fatalf("myapp_prod_errors_total = %v", family)
+	}
+	metric := family.GetMetric()[0]
+	var value float64
+	switch {
+	case metric.Counter != nil:
+		value = metric.Counter.GetValue()
+	case metric.Gauge != nil:
+		value = metric.Gauge.GetValue()
+	default:
+		value = metric.Untyped.GetValue()
+	}
+	if value != 3 || len(metric.GetLabel()) != 1 || metric.GetLabel()[0].GetValue() != "payments" {
+		t.Errorf("myapp_prod_errors_total = %v, want 3 with team=payments", metric)
+	}
+	if _, ok := families["myapp_prod_log_messages_total"]; !ok {
+		t.Error("myapp_prod_log_messages_total was not written")
+	}
+}
@@ -0,0 +1,240 @@
+package prometheuslog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// How a log file is watched for new lines, rotation and truncation.
+const (
+	WatchAuto    = "auto"    // inotify, or polling on network and overlay filesystems and when inotify is unavailable
+	WatchInotify = "inotify" // inotify only; the application fails to start without it
+	WatchPoll    = "poll"    // stat the file every poll interval
+)
+
+const (
+	defaultPollInterval = 250 * time.Millisecond
+	// notifyCheckInterval is how often a file watched with inotify is
+	// checked anyway, in case an event was missed.
+	notifyCheckInterval = 5 * time.Second
+)
+
+func checkWatch(mode string) error {
+	switch mode {
+	case "", WatchAuto, WatchInotify, WatchPoll:
+		return nil
+	}
+	return fmt.Errorf("unknown watch mode %q, expected %s, %s or %s", mode, WatchAuto, WatchInotify, WatchPoll)
+}
+
+// fileWatch tells a fileInput when to look at its file again: when
+// something changes in a watched directory, and every interval.
+type fileWatch struct {
+	watcher     *fileWatcher // nil when polling
+	wake        chan struct{}
+	interval    time.Duration
+	directories map[string]bool
+}
+
+// watch also wakes the input on changes in directory.
+func (watch *fileWatch) watch(directory string) error {
+	if watch.watcher == nil || watch.directories[directory] {
+		return nil
+	}
+	if err := watch.watcher.add(directory, watch.wake); err != nil {
+		return err
+	}
+	watch.directories[directory] = true
+	return nil
+}
+
+func (watch *fileWatch) unwatch(directory string) {
+	if watch.directories[directory] {
+		watch.watcher.remove(directory, watch.wake)
+		delete(watch.directories, directory)
+	}
+}
+
+func (watch *fileWatch) stop() {
+	for directory := range watch.directories {
+		watch.unwatch(directory)
+	}
+}
+
+// watchFile chooses how to watch an application's log file and records the
+// choice in prometheuslog_log_watch_mode. A symlinked log is watched in the
+// directories of both the link and its target.
+func (application *Application) watchFile(logPath string) (*fileWatch, error) {
+	mode, interval := application.Watch, application.PollInterval
+	if mode == "" {
+		mode = application.Config.Watch
+	}
+	if interval <= 0 {
+		interval = application.Config.PollInterval
+	}
+	poll := &fileWatch{interval: interval}
+	directories := []string{filepath.Dir(logPath)}
+	if target := resolveLink(logPath); target != logPath {
+		directories = append(directories, filepath.Dir(target))
+	}
+
+	if mode == WatchAuto {
+		for _, directory := range directories {
+			if filesystem, unreliable := unreliableNotify(directory); unreliable {
+				application.logger.Info("polling log on a filesystem without reliable inotify events", "log", logPath, "filesystem", filesystem)
+				mode = WatchPoll
+				break
+			}
+		}
+	}
+	if mode == WatchPoll {
+		application.files.setWatchMode(application.ApplicationName, WatchPoll)
+		return poll, nil
+	}
+
+	watch := &fileWatch{
+		watcher:     application.watcher,
+		wake:        make(chan struct{}, 1),
+		interval:    notifyCheckInterval,
+		directories: map[string]bool{},
+	}
+	for _, directory := range directories {
+		if err := watch.watch(directory); err != nil {
+			watch.stop()
+			if mode == WatchInotify {
+				return nil, fmt.Errorf("inotify: %v", err)
+			}
+			application.logger.Warn("inotify unavailable, polling log instead", "log", logPath, "error", err)
+			application.files.watchFallbacks.WithLabelValues(application.ApplicationName).Inc()
+			application.files.setWatchMode(application.ApplicationName, WatchPoll)
+			return poll, nil
+		}
+	}
+	application.files.setWatchMode(application.ApplicationName, WatchInotify)
+	return watch, nil
+}
+
+// resolveLink returns the file a symlinked log points to, or logPath when
+// it isn't a symlink or can't be resolved.
+func resolveLink(logPath string) string {
+	target, err := filepath.EvalSymlinks(logPath)
+	if err != nil {
+		return logPath
+	}
+	if target == filepath.Clean(logPath) {
+		return logPath
+	}
+	return target
+}
+
+// fileWatcher shares one inotify instance between every followed file,
+// watching the directories that hold them, so files can be replaced. It is
+// only created when a file is first watched, and closed when the App stops.
+type fileWatcher struct {
+	sync.Mutex
+	logger      *slog.Logger
+	watcher     *fsnotify.Watcher
+	directories map[string][]chan struct{}
+	closed      bool
+}
+
+func newFileWatcher(ctx context.Context, logger *slog.Logger) *fileWatcher {
+	watcher := &fileWatcher{logger: logger, directories: map[string][]chan struct{}{}}
+	go func() {
+		<-ctx.Done()
+		watcher.Lock()
+		defer watcher.Unlock()
+		watcher.closed = true
+		if watcher.watcher != nil {
+			watcher.watcher.Close()
+		}
+	}()
+	return watcher
+}
+
+// add sends a value to wake whenever something in directory changes.
+func (watcher *fileWatcher) add(directory string, wake chan struct{}) error {
+	watcher.Lock()
+	defer watcher.Unlock()
+	if watcher.closed {
+		return errors.New("watcher closed")
+	}
+	if watcher.watcher == nil {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			return err
+		}
+		watcher.watcher = w
+		go watcher.run(w)
+	}
+	if len(watcher.directories[directory]) == 0 {
+		if err := watcher.watcher.Add(directory); err != nil {
+			return err
+		}
+	}
+	watcher.directories[directory] = append(watcher.directories[directory], wake)
+	return nil
+}
+
+func (watcher *fileWatcher) remove(directory string, wake chan struct{}) {
+	watcher.Lock()
+	defer watcher.Unlock()
+	wakes := watcher.directories[directory]
+	for i, existing := range wakes {
+		if existing == wake {
+			wakes = append(wakes[:i], wakes[i+1:]...)
+			break
+		}
+	}
+	if len(wakes) > 0 {
+		watcher.directories[directory] = wakes
+		return
+	}
+	delete(watcher.directories, directory)
+	if !watcher.closed {
+		watcher.watcher.Remove(directory)
+	}
+}
+
+func (watcher *fileWatcher) run(w *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			watcher.wake(filepath.Dir(event.Name))
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			// events may have been lost, e.g. fsnotify.ErrEventOverflow
+			watcher.logger.Warn("inotify error", "error", err)
+			watcher.wake("")
+		}
+	}
+}
+
+// wake notifies every file in directory, or every file when directory is "".
+func (watcher *fileWatcher) wake(directory string) {
+	watcher.Lock()
+	defer watcher.Unlock()
+	for watched, wakes := range watcher.directories {
+		if directory != "" && watched != directory {
+			continue
+		}
+		for _, wake := range wakes {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
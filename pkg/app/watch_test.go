@@ -0,0 +1,159 @@
+package prometheuslog
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// watchLog follows a new log file from its beginning, watched in mode with
+// watcher.
+func watchLog(t *testing.T, watcher *fileWatcher, mode string) (*Application, Input, string, error) {
+	t.Helper()
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(logPath, []byte("first\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	application, input, err := watchPath(t, logPath, watcher, mode)
+	return application, input, logPath, err
+}
+
+// watchPath follows logPath from its beginning, watched in mode with
+// watcher.
+func watchPath(t *testing.T, logPath string, watcher *fileWatcher, mode string) (*Application, Input, error) {
+	t.Helper()
+	app := NewApp()
+	app.files = newFileMetrics(app)
+	app.watcher = watcher
+	application := app.AddApplication(0, "myapp", logPath, 1000, false)
+	application.Watch, application.PollInterval = mode, 50*time.Millisecond
+	application.fromStart = true
+	input, err := application.openInput()
+	if err == nil {
+		t.Cleanup(input.Close)
+	}
+	return application, input, err
+}
+
+// watchModes returns the modes prometheuslog_log_watch_mode is set for.
+func watchModes(files *fileMetrics) []string {
+	metrics := make(chan prometheus.Metric, 10)
+	files.watchMode.Collect(metrics)
+	close(metrics)
+	var modes []string
+	for metric := range metrics {
+		written := &dto.Metric{}
+		metric.Write(written)
+		for _, label := range written.GetLabel() {
+			if label.GetName() == "mode" {
+				modes = append(modes, label.GetValue())
+			}
+		}
+	}
+	return modes
+}
+
+// appendAndRotate appends to a log, then replaces it with a new file.
+func appendAndRotate(t *testing.T, logPath string) {
+	t.Helper()
+	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("second\n")
+	file.Close()
+	if err := os.Rename(logPath, logPath+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(logPath, []byte("third\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchModes(t *testing.T) {
+	tests := []struct {
+		mode string
+		// how long changes may take to be seen: a poll interval, or an
+		// inotify event well before the next check
+		within time.Duration
+	}{
+		{mode: WatchPoll, within: 2 * time.Second},
+		{mode: WatchInotify, within: notifyCheckInterval / 2},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			application, input, logPath, err := watchLog(t, newFileWatcher(ctx, slog.Default()), test.mode)
+			if err != nil {
+				t.Skip(err)
+			}
+			if modes := watchModes(application.files); !slices.Equal(modes, []string{test.mode}) {
+				t.Errorf("watch modes %v, want %s", modes, test.mode)
+			}
+			expectLinesWithin(t, input, test.within, "first")
+
+			// the wait makes sure the changes aren't seen by an
+			// earlier check
+			time.Sleep(100 * time.Millisecond)
+			appendAndRotate(t, logPath)
+			expectLinesWithin(t, input, test.within, "second", "third")
+
+			file, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.WriteString("fourth\n")
+			file.Close()
+			expectLinesWithin(t, input, test.within, "fourth")
+			if got := counterValue(t, application.files.rotations.WithLabelValues("myapp")); got != 1 {
+				t.Errorf("prometheuslog_log_rotations_total = %g, want 1", got)
+			}
+		})
+	}
+}
+
+func TestWatchFallback(t *testing.T) {
+	// a closed watcher fails to watch anything, like one out of inotify
+	// watches
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher := newFileWatcher(ctx, slog.Default())
+	cancel()
+	waitFor(t, "the watcher to close", func() bool {
+		watcher.Lock()
+		defer watcher.Unlock()
+		return watcher.closed
+	})
+
+	t.Run(WatchAuto, func(t *testing.T) {
+		if filesystem, unreliable := unreliableNotify(t.TempDir()); unreliable {
+			t.Skipf("polled anyway on %s", filesystem)
+		}
+		application, input, logPath, err := watchLog(t, watcher, WatchAuto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if modes := watchModes(application.files); !slices.Equal(modes, []string{WatchPoll}) {
+			t.Errorf("watch modes %v, want %s", modes, WatchPoll)
+		}
+		if got := counterValue(t, application.files.watchFallbacks.WithLabelValues("myapp")); got != 1 {
+			t.Errorf("prometheuslog_log_watch_fallbacks_total = %g, want 1", got)
+		}
+		expectLines(t, input, "first")
+		appendAndRotate(t, logPath)
+		expectLines(t, input, "second", "third")
+	})
+
+	t.Run(WatchInotify, func(t *testing.T) {
+		if _, _, _, err := watchLog(t, watcher, WatchInotify); err == nil {
+			t.Error("followed the log without inotify")
+		}
+	})
+}